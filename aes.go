@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"errors"
 	"io"
 )
 
@@ -37,12 +38,27 @@ func AESDecipher(key, ciphertext, iv []byte) (plaintext []byte, err error) {
 		return
 	}
 
+	if len(iv) != aes.BlockSize {
+		return nil, errors.New("iv must be 16 bytes long")
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("ciphertext is not a multiple of the block size")
+	}
+
+	plaintext = make([]byte, len(ciphertext))
 	mode := cipher.NewCBCDecrypter(block, iv)
-	mode.CryptBlocks(ciphertext, ciphertext)
+	mode.CryptBlocks(plaintext, ciphertext)
 
-	size := len(ciphertext)
-	pad := ciphertext[size-1]
-	plaintext = ciphertext[:size-int(pad)]
+	size := len(plaintext)
+	pad := int(plaintext[size-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, errors.New("invalid padding")
+	}
+	for _, b := range plaintext[size-pad:] {
+		if int(b) != pad {
+			return nil, errors.New("invalid padding")
+		}
+	}
 
-	return plaintext, nil
+	return plaintext[:size-pad], nil
 }
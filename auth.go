@@ -42,6 +42,45 @@ func VerifySignature(k1, sig, key string) (ok bool, err error) {
 	return signature.Verify(bk1, pubkey), nil
 }
 
+// Sign signs k1 with the linking key and returns the hex-encoded DER signature and
+// compressed public key, as they should be sent to the callback.
+func (params LNURLAuthParams) Sign(key *btcec.PrivateKey) (sig, pubkey string, err error) {
+	bk1, err := hex.DecodeString(params.K1)
+	if err != nil || len(bk1) != 32 {
+		return "", "", errors.New("k1 is not a valid 32-byte hex-encoded string.")
+	}
+
+	signature := ecdsa.Sign(key, bk1)
+	return hex.EncodeToString(signature.Serialize()),
+		hex.EncodeToString(key.PubKey().SerializeCompressed()),
+		nil
+}
+
+// Call signs k1 with the linking key and sends the signature to the callback.
+func (params LNURLAuthParams) Call(key *btcec.PrivateKey) error {
+	sig, pubkey, err := params.Sign(key)
+	if err != nil {
+		return err
+	}
+
+	callback := params.CallbackURL
+	if callback == nil {
+		parsed, err := url.Parse(params.Callback)
+		if err != nil {
+			return errors.New("callback is not a valid URL")
+		}
+		callback = parsed
+	}
+
+	callback = cloneURL(callback)
+	qs := callback.Query()
+	qs.Set("sig", sig)
+	qs.Set("key", pubkey)
+	callback.RawQuery = qs.Encode()
+
+	return getOK(callback)
+}
+
 func HandleAuth(rawurl string, parsed *url.URL, query url.Values) (LNURLParams, error) {
 	k1 := query.Get("k1")
 	if _, err := hex.DecodeString(k1); err != nil || len(k1) != 64 {
@@ -0,0 +1,112 @@
+package lnurl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// MaxBalanceNotifyURLs is how many balanceNotify URLs BalanceNotifications keeps per link.
+const MaxBalanceNotifyURLs = 10
+
+// BalanceNotifier tells a wallet to check the balance of a reusable withdraw link again.
+type BalanceNotifier interface {
+	Notify(balanceNotify string) error
+}
+
+// HTTPBalanceNotifier notifies wallets with an empty POST request to their balanceNotify
+// URL, as LUD-15 describes. A nil Client means the one used by the rest of the package.
+type HTTPBalanceNotifier struct {
+	Client *http.Client
+}
+
+func (n HTTPBalanceNotifier) Notify(balanceNotify string) error {
+	client := n.Client
+	if client == nil {
+		client = actualClient
+	}
+
+	resp, err := client.Post(balanceNotify, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("http error calling '%s': %w", balanceNotify, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("got status %d from '%s'", resp.StatusCode, balanceNotify)
+	}
+	return nil
+}
+
+// BalanceNotifications keeps the balanceNotify URLs wallets sent to the callbacks of
+// reusable withdraw links, identified by any string the service likes, and notifies them
+// when the balance of a link changes. The zero value is ready to use.
+type BalanceNotifications struct {
+	// Notifier is used to notify wallets, HTTPBalanceNotifier if nil.
+	Notifier BalanceNotifier
+
+	mu   sync.Mutex
+	urls map[string][]string
+}
+
+// Register stores the balanceNotify URL sent by a wallet for a link. It is meant to be
+// called from the withdraw callback with its balanceNotify parameter. As with callbacks,
+// the URL must be https, or http for onion services, so wallets can't make the service
+// call plain http hosts in its network. At most MaxBalanceNotifyURLs are kept per link.
+func (n *BalanceNotifications) Register(link string, balanceNotify string) error {
+	parsed, err := url.Parse(balanceNotify)
+	if err != nil || parsed.Hostname() == "" {
+		return errors.New("balanceNotify is not a valid URL")
+	}
+	onion := strings.HasSuffix(parsed.Hostname(), ".onion")
+	if parsed.Scheme != "https" && !(onion && parsed.Scheme == "http") {
+		return errors.New("balanceNotify must be an https URL")
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.urls == nil {
+		n.urls = make(map[string][]string)
+	}
+	for _, existing := range n.urls[link] {
+		if existing == balanceNotify {
+			return nil
+		}
+	}
+	if len(n.urls[link]) >= MaxBalanceNotifyURLs {
+		return errors.New("too many balanceNotify URLs for this link")
+	}
+	n.urls[link] = append(n.urls[link], balanceNotify)
+	return nil
+}
+
+// Forget removes all the balanceNotify URLs of a link, for when it is disabled.
+func (n *BalanceNotifications) Forget(link string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.urls, link)
+}
+
+// BalanceChanged notifies all the wallets that registered for a link, returning the
+// errors of the ones that couldn't be notified.
+func (n *BalanceNotifications) BalanceChanged(link string) error {
+	n.mu.Lock()
+	urls := append([]string(nil), n.urls[link]...)
+	notifier := n.Notifier
+	n.mu.Unlock()
+
+	if notifier == nil {
+		notifier = HTTPBalanceNotifier{}
+	}
+
+	var errs []error
+	for _, balanceNotify := range urls {
+		if err := notifier.Notify(balanceNotify); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,57 @@
+package lnurl
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestBalanceNotifications(t *testing.T) {
+	var notified []string
+	s := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path == "/gone" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		notified = append(notified, r.URL.Path)
+	}))
+
+	var notifications BalanceNotifications
+	for _, invalid := range []string{"lightning:nothing", "http://10.0.0.1/admin", "ftp://service.com"} {
+		if err := notifications.Register("link", invalid); err == nil {
+			t.Errorf("Register() accepted %s", invalid)
+		}
+	}
+	if err := notifications.Register("onion", "http://wallet.onion/notify"); err != nil {
+		t.Errorf("Register() of an onion URL error = %v", err)
+	}
+	for i := 0; i < MaxBalanceNotifyURLs; i++ {
+		notifications.Register("many", s.URL+"/"+strconv.Itoa(i))
+	}
+	if err := notifications.Register("many", s.URL+"/more"); err == nil {
+		t.Errorf("Register() accepted more than %d URLs", MaxBalanceNotifyURLs)
+	}
+	for _, path := range []string{"/a", "/b", "/a"} {
+		if err := notifications.Register("link", s.URL+path); err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+	}
+	notifications.Register("other", s.URL+"/c")
+
+	if err := notifications.BalanceChanged("link"); err != nil {
+		t.Errorf("BalanceChanged() error = %v", err)
+	}
+	if len(notified) != 2 || notified[0] != "/a" || notified[1] != "/b" {
+		t.Errorf("BalanceChanged() notified %v", notified)
+	}
+
+	notifications.Forget("link")
+	notifications.Register("other", s.URL+"/gone")
+	notified = nil
+	if err := notifications.BalanceChanged("link"); err != nil || len(notified) != 0 {
+		t.Errorf("BalanceChanged() after Forget() notified %v, %v", notified, err)
+	}
+	if err := notifications.BalanceChanged("other"); err == nil || len(notified) != 1 {
+		t.Errorf("BalanceChanged() with a failing wallet notified %v, %v", notified, err)
+	}
+}
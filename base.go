@@ -1,6 +1,9 @@
 package lnurl
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/url"
 )
 
@@ -35,3 +38,33 @@ func ErrorResponse(reason string) LNURLErrorResponse {
 type LNURLParams interface {
 	LNURLKind() string
 }
+
+// getOK calls a callback URL that is expected to respond with an OK or ERROR status, as in
+// lnurl-withdraw, lnurl-auth and lnurl-channel.
+func getOK(callback *url.URL) error {
+	resp, err := actualClient.Get(callback.String())
+	if err != nil {
+		return fmt.Errorf("http error calling '%s': %w", callback.String(), err)
+	}
+	defer resp.Body.Close()
+
+	var response LNURLResponse
+	b, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(b, &response); err != nil {
+		return fmt.Errorf("got invalid JSON from '%s': %w (%s)",
+			callback.String(), err, string(b))
+	}
+
+	if response.Status == "ERROR" {
+		return LNURLErrorResponse{
+			Status: response.Status,
+			Reason: response.Reason,
+			URL:    callback,
+		}
+	}
+	if response.Status != "OK" {
+		return fmt.Errorf("unexpected status '%s' from '%s'", response.Status, callback.String())
+	}
+
+	return nil
+}
@@ -1,43 +1,162 @@
 package lnurl
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
 
 const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
 
-var gen = []int{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+const charsetUpper = "QPZRY9X8GF2TVDW0S3JN54KHCE6MUA7L"
+
+var gen = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// Encoding is the checksum variant of a bech32 string.
+type Encoding int
+
+const (
+	// Bech32 is the original encoding from BIP 173, used by lnurls and invoices.
+	Bech32 Encoding = iota + 1
+	// Bech32m is the encoding from BIP 350, used by newer formats.
+	Bech32m
+)
+
+func (enc Encoding) String() string {
+	switch enc {
+	case Bech32:
+		return "bech32"
+	case Bech32m:
+		return "bech32m"
+	default:
+		return "unknown"
+	}
+}
+
+// constant is the value the checksum of an encoding is XORed with.
+func (enc Encoding) constant() uint32 {
+	if enc == Bech32m {
+		return 0x2bc830a3
+	}
+	return 1
+}
+
+const (
+	// Bech32MaxLength is the limit BIP 173 sets for segwit addresses.
+	Bech32MaxLength = 90
+
+	// LNURLMaxLength is the limit LNURLDecode sets for bech32-encoded lnurls. LUD-01 has no
+	// limit, so this is set high enough for URLs of about 2500 characters, which is more
+	// than what most browsers and QR codes handle, while rejecting absurd inputs early.
+	LNURLMaxLength = 4096
+)
+
+// Bech32Decode decodes a bech32 or bech32m string, returning the lowercase human-readable
+// part, the 5-bit data excluding the checksum and the encoding that was detected. Strings
+// longer than maxLength characters are rejected before any work is done, unless maxLength
+// is 0.
+func Bech32Decode(s string, maxLength int) (hrp string, data []byte, enc Encoding, err error) {
+	if maxLength > 0 && len(s) > maxLength {
+		return "", nil, 0, fmt.Errorf("string is %d characters long, the maximum is %d",
+			len(s), maxLength)
+	}
+
+	hrp, data, enc, err = appendDecode(make([]byte, 0, len(s)), s)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	return strings.ToLower(hrp), data, enc, nil
+}
+
+// Bech32Encode encodes 5-bit data into a lowercase bech32 or bech32m string with the
+// human-readable part hrp. Use ConvertBits to get 5-bit data from bytes. Strings that
+// would be longer than maxLength characters are rejected, unless maxLength is 0, so
+// everything encoded can be decoded by Bech32Decode with the same limit.
+func Bech32Encode(hrp string, data []byte, enc Encoding, maxLength int) (string, error) {
+	if enc != Bech32 && enc != Bech32m {
+		return "", errors.New("unknown encoding")
+	}
+	if len(hrp) < 1 || len(hrp) > 83 {
+		return "", errors.New("human-readable part must be between 1 and 83 characters")
+	}
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return "", fmt.Errorf("invalid character in human-readable part: '%c'", hrp[i])
+		}
+	}
+	if length := len(hrp) + 1 + len(data) + 6; maxLength > 0 && length > maxLength {
+		return "", fmt.Errorf("string would be %d characters long, the maximum is %d",
+			length, maxLength)
+	}
+
+	encoded, err := appendEncode(make([]byte, 0, len(hrp)+len(data)+7),
+		strings.ToLower(hrp), data, enc, false)
+	return string(encoded), err
+}
+
+// ConvertBits converts a byte slice where each byte is encoding fromBits bits to a byte
+// slice where each byte is encoding toBits bits, as needed to go from bytes to the 5-bit
+// groups of bech32 and back. When going to fewer bits, pad should be set so the last
+// group is filled with zeroes; when going back the padding is checked and dropped.
+func ConvertBits(data []byte, fromBits, toBits uint8, pad bool) ([]byte, error) {
+	return convertBits(data, fromBits, toBits, pad)
+}
+
+// charsetRev maps each ASCII character, in both cases, to its index in 'charset',
+// or to -1 if it isn't part of it.
+var charsetRev = func() (rev [128]int8) {
+	for i := range rev {
+		rev[i] = -1
+	}
+	for i := 0; i < len(charset); i++ {
+		rev[charset[i]] = int8(i)
+		rev[charsetUpper[i]] = int8(i)
+	}
+	return rev
+}()
 
 // decode decodes a bech32 encoded string, returning the human-readable
 // part and the data part excluding the checksum.
 func decode(bech string) (string, []byte, error) {
-	// Only ASCII characters between 33 and 126 are allowed.
+	hrp, data, enc, err := appendDecode(make([]byte, 0, len(bech)), bech)
+	if err != nil {
+		return "", nil, err
+	}
+	if enc != Bech32 {
+		return "", nil, errors.New("checksum failed. Got a bech32m string.")
+	}
+	return strings.ToLower(hrp), data, nil
+}
+
+// appendDecode decodes a bech32 or bech32m encoded string, appending the data part
+// excluding the checksum to dst. The human-readable part is returned as it appears in the
+// string, so it is uppercase if the string is.
+func appendDecode(dst []byte, bech string) (string, []byte, Encoding, error) {
+	// Only ASCII characters between 33 and 126 are allowed, and they
+	// must be either all lowercase or all uppercase.
+	hasLower, hasUpper := false, false
 	for i := 0; i < len(bech); i++ {
-		if bech[i] < 33 || bech[i] > 126 {
-			return "", nil, fmt.Errorf("invalid character in "+
-				"string: '%c'", bech[i])
+		c := bech[i]
+		if c < 33 || c > 126 {
+			return "", nil, 0, fmt.Errorf("invalid character in "+
+				"string: '%c'", c)
 		}
+		hasLower = hasLower || ('a' <= c && c <= 'z')
+		hasUpper = hasUpper || ('A' <= c && c <= 'Z')
 	}
-
-	// The characters must be either all lowercase or all uppercase.
-	lower := strings.ToLower(bech)
-	upper := strings.ToUpper(bech)
-	if bech != lower && bech != upper {
-		return "", nil, fmt.Errorf("string not all lowercase or all " +
+	if hasLower && hasUpper {
+		return "", nil, 0, errors.New("string not all lowercase or all " +
 			"uppercase")
 	}
 
-	// We'll work with the lowercase string from now on.
-	bech = lower
-
 	// The string is invalid if the last '1' is non-existent, it is the
 	// first character of the string (no human-readable part) or one of the
-	// last 6 characters of the string (since checksum cannot contain '1'),
-	// or if the string is more than 90 characters in total.
+	// last 6 characters of the string (since checksum cannot contain '1').
+	// BIP 173 also limits strings to 90 characters, but that isn't enforced
+	// here as lnurls are routinely longer.
 	one := strings.LastIndexByte(bech, '1')
 	if one < 1 || one+7 > len(bech) {
-		return "", nil, fmt.Errorf("invalid index of 1")
+		return "", nil, 0, errors.New("invalid index of 1")
 	}
 
 	// The human-readable part is everything before the last '1'.
@@ -45,197 +164,179 @@ func decode(bech string) (string, []byte, error) {
 	data := bech[one+1:]
 
 	// Each character corresponds to the byte with value of the index in
-	// 'charset'.
-	decoded, err := toBytes(data)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed converting data to bytes: "+
-			"%v", err)
-	}
-
-	if !bech32VerifyChecksum(hrp, decoded) {
-		moreInfo := ""
-		checksum := bech[len(bech)-6:]
-		expected, err := toChars(bech32Checksum(hrp,
-			decoded[:len(decoded)-6]))
-		if err == nil {
-			moreInfo = fmt.Sprintf("Expected %v, got %v.",
-				expected, checksum)
+	// 'charset'. The checksum is computed as we go.
+	start := len(dst)
+	chk := bech32HrpPolymod(hrp)
+	for i := 0; i < len(data); i++ {
+		v := charsetRev[data[i]]
+		if v < 0 {
+			return "", nil, 0, fmt.Errorf("failed converting data to bytes: "+
+				"invalid character not part of charset: %v", data[i])
 		}
-		return "", nil, fmt.Errorf("checksum failed. " + moreInfo)
+		chk = bech32PolymodStep(chk, byte(v))
+		dst = append(dst, byte(v))
+	}
+
+	var enc Encoding
+	switch chk {
+	case Bech32.constant():
+		enc = Bech32
+	case Bech32m.constant():
+		enc = Bech32m
+	default:
+		checksum := strings.ToLower(data[len(data)-6:])
+		expected := string(appendChecksum(nil, charset, hrp, dst[start:len(dst)-6], Bech32))
+		moreInfo := fmt.Sprintf("Expected %v, got %v.", expected, checksum)
+		return "", nil, 0, errors.New("checksum failed. " + moreInfo)
 	}
 
 	// We exclude the last 6 bytes, which is the checksum.
-	return hrp, decoded[:len(decoded)-6], nil
+	return hrp, dst[:len(dst)-6], enc, nil
 }
 
 // encode encodes a byte slice into a bech32 string with the
 // human-readable part hrb. Note that the bytes must each encode 5 bits
 // (base32).
 func encode(hrp string, data []byte) (string, error) {
-	// Calculate the checksum of the data and append it at the end.
-	checksum := bech32Checksum(hrp, data)
-	combined := append(data, checksum...)
+	encoded, err := appendEncode(make([]byte, 0, len(hrp)+len(data)+7), hrp, data, Bech32, false)
+	return string(encoded), err
+}
+
+// appendEncode appends the bech32 or bech32m string with the human-readable part hrp and
+// the 5-bit data to dst, all in uppercase if upper is set.
+func appendEncode(dst []byte, hrp string, data []byte, enc Encoding, upper bool) ([]byte, error) {
+	chars := charset
+	if upper {
+		chars = charsetUpper
+	}
 
 	// The resulting bech32 string is the concatenation of the hrp, the
 	// separator 1, data and checksum. Everything after the separator is
 	// represented using the specified charset.
-	dataChars, err := toChars(combined)
-	if err != nil {
-		return "", fmt.Errorf("unable to convert data bytes to chars: "+
-			"%v", err)
+	if upper {
+		for i := 0; i < len(hrp); i++ {
+			dst = append(dst, toUpper(hrp[i]))
+		}
+	} else {
+		dst = append(dst, hrp...)
 	}
-	return hrp + "1" + dataChars, nil
-}
+	dst = append(dst, '1')
 
-// toBytes converts each character in the string 'chars' to the value of the
-// index of the correspoding character in 'charset'.
-func toBytes(chars string) ([]byte, error) {
-	decoded := make([]byte, 0, len(chars))
-	for i := 0; i < len(chars); i++ {
-		index := strings.IndexByte(charset, chars[i])
-		if index < 0 {
-			return nil, fmt.Errorf("invalid character not part of "+
-				"charset: %v", chars[i])
+	for _, b := range data {
+		if int(b) >= len(chars) {
+			return nil, fmt.Errorf("unable to convert data bytes to chars: "+
+				"invalid data byte: %v", b)
 		}
-		decoded = append(decoded, byte(index))
+		dst = append(dst, chars[b])
 	}
-	return decoded, nil
+
+	return appendChecksum(dst, chars, hrp, data, enc), nil
 }
 
-// toChars converts the byte slice 'data' to a string where each byte in 'data'
-// encodes the index of a character in 'charset'.
-func toChars(data []byte) (string, error) {
-	result := make([]byte, 0, len(data))
+// appendChecksum appends the 6 checksum characters for hrp and data to dst.
+// For more details on the checksum calculation, please refer to BIP 173 and BIP 350.
+func appendChecksum(dst []byte, chars string, hrp string, data []byte, enc Encoding) []byte {
+	chk := bech32HrpPolymod(hrp)
 	for _, b := range data {
-		if int(b) >= len(charset) {
-			return "", fmt.Errorf("invalid data byte: %v", b)
-		}
-		result = append(result, charset[b])
+		chk = bech32PolymodStep(chk, b)
+	}
+	for i := 0; i < 6; i++ {
+		chk = bech32PolymodStep(chk, 0)
+	}
+	chk ^= enc.constant()
+
+	for i := 0; i < 6; i++ {
+		dst = append(dst, chars[(chk>>uint(5*(5-i)))&31])
 	}
-	return string(result), nil
+	return dst
 }
 
 // convertBits converts a byte slice where each byte is encoding fromBits bits,
 // to a byte slice where each byte is encoding toBits bits.
 func convertBits(data []byte, fromBits, toBits uint8, pad bool) ([]byte, error) {
-	if fromBits < 1 || fromBits > 8 || toBits < 1 || toBits > 8 {
-		return nil, fmt.Errorf("only bit groups between 1 and 8 allowed")
+	if toBits < 1 || toBits > 8 {
+		return nil, errors.New("only bit groups between 1 and 8 allowed")
 	}
+	return appendConvertBits(make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1),
+		data, fromBits, toBits, pad)
+}
 
-	// The final bytes, each byte encoding toBits bits.
-	var regrouped []byte
+// appendConvertBits converts data where each byte is encoding fromBits bits to bytes
+// encoding toBits bits, appending them to dst. As the output of converting to fewer
+// bits is never longer than the input, dst can be data[:0] in that case.
+func appendConvertBits[T string | []byte](dst []byte, data T, fromBits, toBits uint8, pad bool) ([]byte, error) {
+	if fromBits < 1 || fromBits > 8 || toBits < 1 || toBits > 8 {
+		return nil, errors.New("only bit groups between 1 and 8 allowed")
+	}
 
-	// Keep track of the next byte we create and how many bits we have
-	// added to it out of the toBits goal.
-	nextByte := byte(0)
+	// Bits are accumulated and taken out in groups of toBits as soon as
+	// there are enough. Unused high bits of each input byte are discarded.
+	var acc uint32
 	filledBits := uint8(0)
-
-	for _, b := range data {
-
-		// Discard unused bits.
-		b = b << (8 - fromBits)
-
-		// How many bits remaining to extract from the input data.
-		remFromBits := fromBits
-		for remFromBits > 0 {
-			// How many bits remaining to be added to the next byte.
-			remToBits := toBits - filledBits
-
-			// The number of bytes to next extract is the minimum of
-			// remFromBits and remToBits.
-			toExtract := remFromBits
-			if remToBits < toExtract {
-				toExtract = remToBits
-			}
-
-			// Add the next bits to nextByte, shifting the already
-			// added bits to the left.
-			nextByte = (nextByte << toExtract) | (b >> (8 - toExtract))
-
-			// Discard the bits we just extracted and get ready for
-			// next iteration.
-			b = b << toExtract
-			remFromBits -= toExtract
-			filledBits += toExtract
-
-			// If the nextByte is completely filled, we add it to
-			// our regrouped bytes and start on the next byte.
-			if filledBits == toBits {
-				regrouped = append(regrouped, nextByte)
-				filledBits = 0
-				nextByte = 0
-			}
+	fromMask := uint32(1)<<fromBits - 1
+	toMask := uint32(1)<<toBits - 1
+
+	for i := 0; i < len(data); i++ {
+		acc = acc<<fromBits | uint32(data[i])&fromMask
+		filledBits += fromBits
+		for filledBits >= toBits {
+			filledBits -= toBits
+			dst = append(dst, byte(acc>>filledBits&toMask))
 		}
+		acc &= uint32(1)<<filledBits - 1
 	}
 
 	// We pad any unfinished group if specified.
 	if pad && filledBits > 0 {
-		nextByte = nextByte << (toBits - filledBits)
-		regrouped = append(regrouped, nextByte)
+		dst = append(dst, byte(acc<<(toBits-filledBits)&toMask))
 		filledBits = 0
-		nextByte = 0
+		acc = 0
 	}
 
 	// Any incomplete group must be <= 4 bits, and all zeroes.
-	if filledBits > 0 && (filledBits > 4 || nextByte != 0) {
-		return nil, fmt.Errorf("invalid incomplete group")
+	if filledBits > 0 && (filledBits > 4 || acc != 0) {
+		return nil, errors.New("invalid incomplete group")
 	}
 
-	return regrouped, nil
-}
-
-// For more details on the checksum calculation, please refer to BIP 173.
-func bech32Checksum(hrp string, data []byte) []byte {
-	// Convert the bytes to list of integers, as this is needed for the
-	// checksum calculation.
-	integers := make([]int, len(data))
-	for i, b := range data {
-		integers[i] = int(b)
-	}
-	values := append(bech32HrpExpand(hrp), integers...)
-	values = append(values, []int{0, 0, 0, 0, 0, 0}...)
-	polymod := bech32Polymod(values) ^ 1
-	var res []byte
-	for i := 0; i < 6; i++ {
-		res = append(res, byte((polymod>>uint(5*(5-i)))&31))
-	}
-	return res
+	return dst, nil
 }
 
 // For more details on the polymod calculation, please refer to BIP 173.
-func bech32Polymod(values []int) int {
-	chk := 1
-	for _, v := range values {
-		b := chk >> 25
-		chk = (chk&0x1ffffff)<<5 ^ v
-		for i := 0; i < 5; i++ {
-			if (b>>uint(i))&1 == 1 {
-				chk ^= gen[i]
-			}
+func bech32PolymodStep(chk uint32, v byte) uint32 {
+	b := chk >> 25
+	chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+	for i := 0; i < 5; i++ {
+		if (b>>uint(i))&1 == 1 {
+			chk ^= gen[i]
 		}
 	}
 	return chk
 }
 
-// For more details on HRP expansion, please refer to BIP 173.
-func bech32HrpExpand(hrp string) []int {
-	v := make([]int, 0, len(hrp)*2+1)
+// bech32HrpPolymod is the polymod state after the expanded human-readable part, which
+// is always taken in lowercase. For more details on HRP expansion, please refer to BIP 173.
+func bech32HrpPolymod(hrp string) uint32 {
+	chk := uint32(1)
 	for i := 0; i < len(hrp); i++ {
-		v = append(v, int(hrp[i]>>5))
+		chk = bech32PolymodStep(chk, toLower(hrp[i])>>5)
 	}
-	v = append(v, 0)
+	chk = bech32PolymodStep(chk, 0)
 	for i := 0; i < len(hrp); i++ {
-		v = append(v, int(hrp[i]&31))
+		chk = bech32PolymodStep(chk, toLower(hrp[i])&31)
+	}
+	return chk
+}
+
+func toLower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
 	}
-	return v
+	return c
 }
 
-// For more details on the checksum verification, please refer to BIP 173.
-func bech32VerifyChecksum(hrp string, data []byte) bool {
-	integers := make([]int, len(data))
-	for i, b := range data {
-		integers[i] = int(b)
+func toUpper(c byte) byte {
+	if 'a' <= c && c <= 'z' {
+		return c - ('a' - 'A')
 	}
-	concat := append(bech32HrpExpand(hrp), integers...)
-	return bech32Polymod(concat) == 1
+	return c
 }
@@ -0,0 +1,163 @@
+package lnurl
+
+import (
+	"strings"
+	"testing"
+)
+
+var (
+	benchURL   = "https://service.com/api?q=3fc3645b439ce8e7f2553a69e5267081d96dcd340693afabe04be7b0ccd178df"
+	benchLNURL = "LNURL1DP68GURN8GHJ7UM9WFMXJCM99E3K7MF0V9CXJ0M385EKVCENXC6R2C35XVUKXEFCV5MKVV34X5EKZD3EV56NYD3HXQURZEPEXEJXXEPNXSCRVWFNV9NXZCN9XQ6XYEFHVGCXXCMYXYMNSERXFQ5FNS"
+)
+
+func TestBech32Vectors(t *testing.T) {
+	// valid strings from BIP 173
+	for _, s := range []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"11" + strings.Repeat("q", 82) + "c8247j",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+		"?1ezyfcl",
+	} {
+		hrp, data, err := decode(s)
+		if err != nil {
+			t.Errorf("decode(%s) error = %v", s, err)
+			continue
+		}
+		if encoded, err := encode(hrp, data); err != nil || encoded != strings.ToLower(s) {
+			t.Errorf("encode() got = %v, %v, want %v", encoded, err, strings.ToLower(s))
+		}
+	}
+
+	// invalid strings from BIP 173, except the ones over 90 characters
+	for _, s := range []string{
+		"\x201nwldj5",
+		"\x7f1axkwrx",
+		"pzry9x0s0muk",
+		"1pzry9x0s0muk",
+		"x1b4n0q5v",
+		"li1dgmt3",
+		"de1lg7wt\xff",
+		"A1G7SGD8",
+		"10a06t8",
+		"1qzzfhee",
+		"a12UEL5L",
+	} {
+		if _, _, err := decode(s); err == nil {
+			t.Errorf("decode(%q) accepted an invalid string", s)
+		}
+	}
+}
+
+func TestBech32mVectors(t *testing.T) {
+	// valid strings from BIP 350
+	for _, s := range []string{
+		"A1LQFN3A",
+		"a1lqfn3a",
+		"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6",
+		"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx",
+		"11" + strings.Repeat("l", 83) + "udsr8",
+		"split1checkupstagehandshakeupstreamerranterredcaperredlc445v",
+		"?1v759aa",
+	} {
+		hrp, data, enc, err := Bech32Decode(s, Bech32MaxLength)
+		if err != nil || enc != Bech32m {
+			t.Errorf("Bech32Decode(%s) got = %v, %v", s, enc, err)
+			continue
+		}
+		if encoded, err := Bech32Encode(hrp, data, Bech32m, Bech32MaxLength); err != nil || encoded != strings.ToLower(s) {
+			t.Errorf("Bech32Encode() got = %v, %v, want %v", encoded, err, strings.ToLower(s))
+		}
+		if _, _, err := decode(s); err == nil {
+			t.Errorf("decode(%s) accepted a bech32m string", s)
+		}
+	}
+
+	if _, _, _, err := Bech32Decode("a12uel5l", 7); err == nil {
+		t.Errorf("Bech32Decode() ignored the maximum length")
+	}
+	if _, _, enc, err := Bech32Decode("a12uel5l", 0); err != nil || enc != Bech32 {
+		t.Errorf("Bech32Decode() got = %v, %v", enc, err)
+	}
+	if _, err := Bech32Encode("a", make([]byte, Bech32MaxLength-7), Bech32, Bech32MaxLength); err == nil {
+		t.Errorf("Bech32Encode() ignored the maximum length")
+	}
+	if encoded, err := Bech32Encode("a", make([]byte, Bech32MaxLength-8), Bech32, Bech32MaxLength); err != nil {
+		t.Errorf("Bech32Encode() error = %v", err)
+	} else if _, _, _, err := Bech32Decode(encoded, Bech32MaxLength); err != nil {
+		t.Errorf("Bech32Decode() of a %d characters string error = %v", len(encoded), err)
+	}
+	if _, err := LNURLDecode("lnurl1" + strings.Repeat("q", LNURLMaxLength)); err == nil ||
+		!strings.Contains(err.Error(), "maximum") {
+		t.Errorf("LNURLDecode() of an overlong lnurl error = %v", err)
+	}
+	// 2552 characters make an lnurl of exactly LNURLMaxLength
+	if encoded, err := LNURLEncode(strings.Repeat("a", 2552)); err != nil {
+		t.Errorf("LNURLEncode() of the longest URL error = %v", err)
+	} else if _, err := LNURLDecode(encoded); err != nil {
+		t.Errorf("LNURLDecode() of the longest lnurl error = %v", err)
+	}
+	if _, err := LNURLEncode(strings.Repeat("a", 2553)); err == nil {
+		t.Errorf("LNURLEncode() made an lnurl LNURLDecode rejects")
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := decode(benchLNURL); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	data, _ := convertBits([]byte(benchURL), 8, 5, true)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := encode("lnurl", data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConvertBits(b *testing.B) {
+	data := []byte(benchURL)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := convertBits(data, 8, 5, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLNURLDecode(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := LNURLDecode(benchLNURL); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLNURLEncode(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := LNURLEncode(benchURL); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindLNURLInText(b *testing.B) {
+	text := "please pay to " + benchLNURL + " as soon as possible, thanks!"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, ok := FindLNURLInText(text); !ok {
+			b.Fatal("not found")
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Package boltcard verifies the taps of Bolt Cards, NFC cards that make wallets call an
+// lnurl-withdraw endpoint with a URL they sign on every tap using NXP's Secure Unique NFC
+// (SUN) messages.
+//
+// The URL carries p, the UID of the card and a tap counter encrypted with the K1 key, and
+// c, an AES-CMAC of them made with the K2 key specific to the card. A tap is only valid
+// once: the counter must increase every time.
+package boltcard
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/fiatjaf/go-lnurl"
+)
+
+// ErrReplay is returned for taps with a counter that isn't greater than the last one seen.
+var ErrReplay = errors.New("card counter is not increasing, the tap was replayed")
+
+// Tap is what a verified tap tells about a card.
+type Tap struct {
+	// UID is the hex-encoded 7-byte UID of the card.
+	UID     string
+	Counter uint32
+}
+
+// Decrypt decrypts p with the K1 key of the card, returning its UID and tap counter.
+func Decrypt(p []byte, k1 []byte) (uid []byte, counter uint32, err error) {
+	if len(p) != aes.BlockSize {
+		return nil, 0, errors.New("p must be 16 bytes long")
+	}
+	block, err := aes.NewCipher(k1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// a single block encrypted with CBC and a zero IV is the same as with ECB
+	plain := make([]byte, aes.BlockSize)
+	block.Decrypt(plain, p)
+	if plain[0] != 0xc7 {
+		return nil, 0, errors.New("p couldn't be decrypted with this key")
+	}
+
+	uid = plain[1:8]
+	counter = uint32(plain[8]) | uint32(plain[9])<<8 | uint32(plain[10])<<16
+	return uid, counter, nil
+}
+
+// VerifyCMAC checks c, the truncated CMAC of the UID and counter made with the K2 key of
+// the card.
+func VerifyCMAC(uid []byte, counter uint32, c []byte, k2 []byte) error {
+	if len(uid) != 7 {
+		return errors.New("uid must be 7 bytes long")
+	}
+
+	sv2 := make([]byte, 0, 16)
+	sv2 = append(sv2, 0x3c, 0xc3, 0x00, 0x01, 0x00, 0x80)
+	sv2 = append(sv2, uid...)
+	sv2 = append(sv2, byte(counter), byte(counter>>8), byte(counter>>16))
+
+	ks, err := CMAC(k2, sv2)
+	if err != nil {
+		return err
+	}
+	mac, err := CMAC(ks, nil)
+	if err != nil {
+		return err
+	}
+
+	truncated := make([]byte, 8)
+	for i := range truncated {
+		truncated[i] = mac[i*2+1]
+	}
+	if subtle.ConstantTimeCompare(truncated, c) != 1 {
+		return errors.New("invalid card signature")
+	}
+	return nil
+}
+
+// CounterStore keeps the last counter seen for each card.
+type CounterStore interface {
+	// Advance stores counter as the last one seen for the card if it is greater than the
+	// stored one, and returns ErrReplay otherwise. It must be atomic.
+	Advance(uid string, counter uint32) error
+}
+
+// MemoryCounters is a CounterStore in memory. The zero value is ready to use.
+type MemoryCounters struct {
+	mu       sync.Mutex
+	counters map[string]uint32
+}
+
+func (m *MemoryCounters) Advance(uid string, counter uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if last, ok := m.counters[uid]; ok && counter <= last {
+		return ErrReplay
+	}
+	if m.counters == nil {
+		m.counters = make(map[string]uint32)
+	}
+	m.counters[uid] = counter
+	return nil
+}
+
+// Verifier verifies taps.
+type Verifier struct {
+	// K1s are tried in order to decrypt p. Services usually program all their cards with
+	// the same K1 so the card can be found before knowing its UID.
+	K1s [][]byte
+
+	// K2 returns the K2 key of the card with the given hex-encoded UID, or an error if the
+	// card is unknown.
+	K2 func(uid string) ([]byte, error)
+
+	Counters CounterStore
+}
+
+// Verify checks the hex-encoded p and c parameters of a tap and advances the counter of
+// the card.
+func (v *Verifier) Verify(p string, c string) (*Tap, error) {
+	bp, err := hex.DecodeString(p)
+	if err != nil {
+		return nil, errors.New("p is not valid hex")
+	}
+	bc, err := hex.DecodeString(c)
+	if err != nil || len(bc) != 8 {
+		return nil, errors.New("c is not an 8-byte hex-encoded string")
+	}
+
+	// a wrong K1 still gives the right header 1 time in 256, so only the CMAC tells
+	err = errors.New("unknown card")
+	for _, k1 := range v.K1s {
+		uid, counter, derr := Decrypt(bp, k1)
+		if derr != nil {
+			continue
+		}
+
+		tap := &Tap{UID: hex.EncodeToString(uid), Counter: counter}
+		var k2 []byte
+		if k2, err = v.K2(tap.UID); err != nil {
+			continue
+		}
+		if err = VerifyCMAC(uid, counter, bc, k2); err != nil {
+			continue
+		}
+
+		if err := v.Counters.Advance(tap.UID, counter); err != nil {
+			return nil, err
+		}
+		return tap, nil
+	}
+	return nil, err
+}
+
+// Handler serves the lnurlw:// endpoint the cards point to. It verifies the p and c
+// parameters and responds with the withdrawRequest given by withdraw for the tap, or with
+// an ERROR.
+func (v *Verifier) Handler(withdraw func(tap Tap) (lnurl.LNURLWithdrawResponse, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		query := r.URL.Query()
+		tap, err := v.Verify(query.Get("p"), query.Get("c"))
+		if err != nil {
+			json.NewEncoder(w).Encode(lnurl.ErrorResponse(err.Error()))
+			return
+		}
+
+		params, err := withdraw(*tap)
+		if err != nil {
+			json.NewEncoder(w).Encode(lnurl.ErrorResponse(err.Error()))
+			return
+		}
+		params.Tag = "withdrawRequest"
+		json.NewEncoder(w).Encode(params)
+	})
+}
@@ -0,0 +1,112 @@
+package boltcard
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiatjaf/go-lnurl"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestCMAC(t *testing.T) {
+	// from RFC 4493
+	key := unhex("2b7e151628aed2a6abf7158809cf4f3c")
+	for _, tt := range []struct {
+		msg  string
+		want string
+	}{
+		{"", "bb1d6929e95937287fa37d129b756746"},
+		{"6bc1bee22e409f96e93d7e117393172a", "070a16b46b4d4144f79bdd9dd04a287c"},
+		{"6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411", "dfa66747de9ae63030ca32611497c827"},
+		{"6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710", "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		mac, err := CMAC(key, unhex(tt.msg))
+		if err != nil || hex.EncodeToString(mac) != tt.want {
+			t.Errorf("CMAC(%s) got = %x, %v, want %s", tt.msg, mac, err, tt.want)
+		}
+	}
+}
+
+// test vectors from the Bolt Card spec
+var (
+	testK1 = unhex("0c3b25d92b38ae443229dd59ad34b85d")
+	testK2 = unhex("b45775776cb224c75bcde7ca3704e933")
+	taps   = []struct {
+		p, c    string
+		counter uint32
+	}{
+		{"4E2E289D945A66BB13377A728884E867", "E19CCB1FED8892CE", 3},
+		{"00F48C4F8E386DED06BCDC78FA92E2FE", "66B4826EA4C155B4", 5},
+		{"0DBF3C59B59B0638D60B5842A997D4D1", "CC61660C020B4D96", 7},
+	}
+)
+
+func TestDecryptAndVerifyCMAC(t *testing.T) {
+	for _, tt := range taps {
+		uid, counter, err := Decrypt(unhex(tt.p), testK1)
+		if err != nil || hex.EncodeToString(uid) != "04996c6a926980" || counter != tt.counter {
+			t.Errorf("Decrypt(%s) got = %x, %d, %v", tt.p, uid, counter, err)
+			continue
+		}
+		if err := VerifyCMAC(uid, counter, unhex(tt.c), testK2); err != nil {
+			t.Errorf("VerifyCMAC(%s) error = %v", tt.c, err)
+		}
+		if err := VerifyCMAC(uid, counter+1, unhex(tt.c), testK2); err == nil {
+			t.Errorf("VerifyCMAC() accepted the wrong counter")
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	v := &Verifier{
+		K1s: [][]byte{unhex("00000000000000000000000000000000"), testK1},
+		K2: func(uid string) ([]byte, error) {
+			if uid != "04996c6a926980" {
+				return nil, errors.New("unknown card")
+			}
+			return testK2, nil
+		},
+		Counters: &MemoryCounters{},
+	}
+	s := httptest.NewTLSServer(v.Handler(func(tap Tap) (lnurl.LNURLWithdrawResponse, error) {
+		return lnurl.LNURLWithdrawResponse{
+			K1:              tap.UID,
+			Callback:        "https://service.com/callback",
+			MinWithdrawable: 1000,
+			MaxWithdrawable: 100000,
+		}, nil
+	}))
+	defer s.Close()
+	previous := lnurl.WithCustomClient(s.Client())
+	defer lnurl.WithCustomClient(previous)
+
+	tap := func(i int) (lnurl.LNURLParams, error) {
+		_, params, err := lnurl.HandleLNURL("lnurlw://" + strings.TrimPrefix(s.URL, "https://") +
+			"/?p=" + taps[i].p + "&c=" + taps[i].c)
+		return params, err
+	}
+
+	params, err := tap(1)
+	if err != nil || params.(lnurl.LNURLWithdrawResponse).K1 != "04996c6a926980" {
+		t.Fatalf("tap got = %v, %v", params, err)
+	}
+	if _, err := tap(0); err == nil || err.Error() != ErrReplay.Error() {
+		t.Errorf("tap with an older counter error = %v", err)
+	}
+	if _, err := tap(1); err == nil || err.Error() != ErrReplay.Error() {
+		t.Errorf("tap with the same counter error = %v", err)
+	}
+	if _, err := tap(2); err != nil {
+		t.Errorf("tap with a newer counter error = %v", err)
+	}
+}
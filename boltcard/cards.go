@@ -0,0 +1,421 @@
+package boltcard
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/go-lnurl"
+)
+
+var (
+	// ErrUnknownCard is returned by card stores for UIDs they don't have.
+	ErrUnknownCard = errors.New("unknown card")
+
+	// ErrLimit is returned when a withdrawal would go over the limits of a card.
+	ErrLimit = errors.New("card spending limit reached")
+)
+
+// Keys are the five AES-128 keys of a card: K0 is the application master key, K1
+// encrypts p, K2 signs c and K3 and K4 are unused by Bolt Cards but must be set.
+type Keys struct {
+	K0, K1, K2, K3, K4 []byte
+}
+
+// NewKeys generates random keys for a card. If k1 isn't nil it is used as K1, as it is
+// usually shared by all the cards of a service.
+func NewKeys(k1 []byte) (Keys, error) {
+	var keys Keys
+	for _, key := range []*[]byte{&keys.K0, &keys.K1, &keys.K2, &keys.K3, &keys.K4} {
+		*key = make([]byte, 16)
+		if _, err := rand.Read(*key); err != nil {
+			return Keys{}, err
+		}
+	}
+	if k1 != nil {
+		if len(k1) != 16 {
+			return Keys{}, errors.New("k1 must be 16 bytes long")
+		}
+		keys.K1 = append([]byte(nil), k1...)
+	}
+	return keys, nil
+}
+
+// Limits are the spending limits of a card, in millisatoshis. Zero means no limit.
+type Limits struct {
+	PerTap int64
+	PerDay int64
+}
+
+// Card is the record of an issued card.
+type Card struct {
+	// UID is the hex-encoded UID of the card.
+	UID  string
+	Name string
+	Keys Keys
+
+	// Version is incremented every time the keys are rotated.
+	Version int
+	Limits  Limits
+	Enabled bool
+}
+
+// ProgrammingPayload is what card programmer apps read, usually from a QR code, to write
+// the keys and the lnurlw URL to a card.
+type ProgrammingPayload struct {
+	ProtocolName    string `json:"protocol_name"`
+	ProtocolVersion int    `json:"protocol_version"`
+	CardName        string `json:"card_name"`
+	LNURLWBase      string `json:"lnurlw_base"`
+	K0              string `json:"k0"`
+	K1              string `json:"k1"`
+	K2              string `json:"k2"`
+	K3              string `json:"k3"`
+	K4              string `json:"k4"`
+}
+
+// WipePayload is what card programmer apps read to reset a card to its factory keys.
+type WipePayload struct {
+	Action  string `json:"action"`
+	UID     string `json:"uid"`
+	Version int    `json:"version"`
+	K0      string `json:"k0"`
+	K1      string `json:"k1"`
+	K2      string `json:"k2"`
+	K3      string `json:"k3"`
+	K4      string `json:"k4"`
+}
+
+// ProgrammingPayload returns the payload to program the card so it points to lnurlwBase,
+// an https URL or an lnurlw:// one (LUD-17).
+func (card Card) ProgrammingPayload(lnurlwBase string) (ProgrammingPayload, error) {
+	base, err := lnurlwURL(lnurlwBase)
+	if err != nil {
+		return ProgrammingPayload{}, err
+	}
+
+	return ProgrammingPayload{
+		ProtocolName:    "new_bolt_card_response",
+		ProtocolVersion: 1,
+		CardName:        card.Name,
+		LNURLWBase:      base,
+		K0:              hex.EncodeToString(card.Keys.K0),
+		K1:              hex.EncodeToString(card.Keys.K1),
+		K2:              hex.EncodeToString(card.Keys.K2),
+		K3:              hex.EncodeToString(card.Keys.K3),
+		K4:              hex.EncodeToString(card.Keys.K4),
+	}, nil
+}
+
+// WipePayload returns the payload to wipe the card, which needs its current keys.
+func (card Card) WipePayload() WipePayload {
+	return WipePayload{
+		Action:  "wipe",
+		UID:     card.UID,
+		Version: card.Version,
+		K0:      hex.EncodeToString(card.Keys.K0),
+		K1:      hex.EncodeToString(card.Keys.K1),
+		K2:      hex.EncodeToString(card.Keys.K2),
+		K3:      hex.EncodeToString(card.Keys.K3),
+		K4:      hex.EncodeToString(card.Keys.K4),
+	}
+}
+
+// Rotate returns the card with new keys, K1 being k1 if not nil, and its version
+// incremented. The card must be wiped with its current keys and programmed again.
+func (card Card) Rotate(k1 []byte) (Card, error) {
+	keys, err := NewKeys(k1)
+	if err != nil {
+		return card, err
+	}
+	card.Keys = keys
+	card.Version++
+	return card, nil
+}
+
+// lnurlwURL turns an https URL into its lnurlw:// form, keeping lnurlw:// URLs as they are.
+func lnurlwURL(base string) (string, error) {
+	parsed, err := url.Parse(base)
+	if err != nil || parsed.Host == "" {
+		return "", fmt.Errorf("invalid lnurlw base '%s'", base)
+	}
+
+	switch parsed.Scheme {
+	case "lnurlw":
+	case "https":
+		parsed.Scheme = "lnurlw"
+	case "http":
+		if !strings.HasSuffix(parsed.Hostname(), ".onion") {
+			return "", errors.New("lnurlw base must be https unless it is an onion")
+		}
+		parsed.Scheme = "lnurlw"
+	default:
+		return "", fmt.Errorf("invalid lnurlw base scheme '%s'", parsed.Scheme)
+	}
+	return parsed.String(), nil
+}
+
+// CardStore keeps the records of issued cards and what was spent with them.
+type CardStore interface {
+	// Card returns the card with the given UID, or ErrUnknownCard.
+	Card(uid string) (*Card, error)
+
+	// Save adds or replaces a card.
+	Save(card Card) error
+
+	// Spend records msats spent with the card at the given time, returning ErrLimit if
+	// that goes over its limits. It must be atomic and reject msats that aren't positive.
+	Spend(uid string, msats int64, at time.Time) error
+
+	// Available returns how much can still be spent with the card on the day of at.
+	Available(uid string, at time.Time) (int64, error)
+}
+
+// MemoryCards is a CardStore in memory. The zero value is ready to use. Days are UTC.
+type MemoryCards struct {
+	mu    sync.Mutex
+	cards map[string]Card
+	spent map[string]map[string]int64
+}
+
+func (m *MemoryCards) Card(uid string) (*Card, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	card, ok := m.cards[strings.ToLower(uid)]
+	if !ok {
+		return nil, ErrUnknownCard
+	}
+	return &card, nil
+}
+
+func (m *MemoryCards) Save(card Card) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cards == nil {
+		m.cards = make(map[string]Card)
+	}
+	m.cards[strings.ToLower(card.UID)] = card
+	return nil
+}
+
+func (m *MemoryCards) Spend(uid string, msats int64, at time.Time) error {
+	if msats <= 0 {
+		return errors.New("amount must be positive")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	uid = strings.ToLower(uid)
+	available, err := m.available(uid, at)
+	if err != nil {
+		return err
+	}
+	if msats > available {
+		return ErrLimit
+	}
+
+	if m.spent == nil {
+		m.spent = make(map[string]map[string]int64)
+	}
+	day := at.UTC().Format("2006-01-02")
+	if m.spent[uid] == nil {
+		m.spent[uid] = make(map[string]int64)
+	}
+	m.spent[uid][day] += msats
+	return nil
+}
+
+func (m *MemoryCards) Available(uid string, at time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.available(strings.ToLower(uid), at)
+}
+
+func (m *MemoryCards) available(uid string, at time.Time) (int64, error) {
+	card, ok := m.cards[uid]
+	if !ok {
+		return 0, ErrUnknownCard
+	}
+	if !card.Enabled {
+		return 0, nil
+	}
+
+	available := int64(1<<63 - 1)
+	if card.Limits.PerTap > 0 {
+		available = card.Limits.PerTap
+	}
+	if card.Limits.PerDay > 0 {
+		left := card.Limits.PerDay - m.spent[uid][at.UTC().Format("2006-01-02")]
+		available = min(available, max(left, 0))
+	}
+	return available, nil
+}
+
+// Issuer issues cards pointing to one lnurlw endpoint and verifies their taps.
+type Issuer struct {
+	// LNURLWBase is the URL the cards point to, where Handler is served.
+	LNURLWBase string
+
+	// K1 is shared by all the cards issued, which is how Verifier decrypts their taps. It
+	// must be set, as 16 bytes, before issuing cards.
+	K1 []byte
+
+	Cards    CardStore
+	Counters CounterStore
+
+	mu      sync.Mutex
+	pending map[string]pendingTap
+}
+
+// pendingTap is a withdrawRequest issued for a tap, waiting for the callback.
+type pendingTap struct {
+	uid     string
+	expires time.Time
+}
+
+// TapTimeout is how long the withdrawRequest issued for a tap can be used.
+const TapTimeout = 5 * time.Minute
+
+// Issue creates and stores the record of a card and returns the payload to program it.
+func (i *Issuer) Issue(uid string, name string, limits Limits) (*Card, ProgrammingPayload, error) {
+	if b, err := hex.DecodeString(uid); err != nil || len(b) != 7 {
+		return nil, ProgrammingPayload{}, errors.New("uid must be a 7-byte hex-encoded string")
+	}
+	if len(i.K1) != 16 {
+		return nil, ProgrammingPayload{}, errors.New("issuer K1 must be 16 bytes")
+	}
+
+	keys, err := NewKeys(i.K1)
+	if err != nil {
+		return nil, ProgrammingPayload{}, err
+	}
+	card := Card{
+		UID:     strings.ToLower(uid),
+		Name:    name,
+		Keys:    keys,
+		Version: 1,
+		Limits:  limits,
+		Enabled: true,
+	}
+
+	payload, err := card.ProgrammingPayload(i.LNURLWBase)
+	if err != nil {
+		return nil, ProgrammingPayload{}, err
+	}
+	if err := i.Cards.Save(card); err != nil {
+		return nil, ProgrammingPayload{}, err
+	}
+	return &card, payload, nil
+}
+
+// Rotate gives a card new keys, returning the payloads to wipe it with the old keys and
+// to program it again with the new ones.
+func (i *Issuer) Rotate(uid string) (*Card, WipePayload, ProgrammingPayload, error) {
+	if len(i.K1) != 16 {
+		return nil, WipePayload{}, ProgrammingPayload{}, errors.New("issuer K1 must be 16 bytes")
+	}
+	card, err := i.Cards.Card(uid)
+	if err != nil {
+		return nil, WipePayload{}, ProgrammingPayload{}, err
+	}
+	wipe := card.WipePayload()
+
+	rotated, err := card.Rotate(i.K1)
+	if err != nil {
+		return nil, WipePayload{}, ProgrammingPayload{}, err
+	}
+	payload, err := rotated.ProgrammingPayload(i.LNURLWBase)
+	if err != nil {
+		return nil, WipePayload{}, ProgrammingPayload{}, err
+	}
+	if err := i.Cards.Save(rotated); err != nil {
+		return nil, WipePayload{}, ProgrammingPayload{}, err
+	}
+	return &rotated, wipe, payload, nil
+}
+
+// Verifier returns a Verifier for the cards issued.
+func (i *Issuer) Verifier() *Verifier {
+	return &Verifier{
+		K1s: [][]byte{i.K1},
+		K2: func(uid string) ([]byte, error) {
+			card, err := i.Cards.Card(uid)
+			if err != nil {
+				return nil, err
+			}
+			if !card.Enabled {
+				return nil, errors.New("card is disabled")
+			}
+			return card.Keys.K2, nil
+		},
+		Counters: i.Counters,
+	}
+}
+
+// Handler serves the endpoint at LNURLWBase. Each valid tap gets a withdrawRequest for
+// what is still available to the card, with a new k1 and callback, the URL the withdraw
+// callback is served at, which must call Spend before paying.
+func (i *Issuer) Handler(callback string, description string) http.Handler {
+	return i.Verifier().Handler(func(tap Tap) (lnurl.LNURLWithdrawResponse, error) {
+		available, err := i.Cards.Available(tap.UID, time.Now())
+		if err != nil {
+			return lnurl.LNURLWithdrawResponse{}, err
+		}
+		if available < 1000 {
+			return lnurl.LNURLWithdrawResponse{}, ErrLimit
+		}
+
+		k1 := lnurl.RandomK1()
+		i.mu.Lock()
+		if i.pending == nil {
+			i.pending = make(map[string]pendingTap)
+		}
+		now := time.Now()
+		for k, pending := range i.pending {
+			if now.After(pending.expires) {
+				delete(i.pending, k)
+			}
+		}
+		i.pending[k1] = pendingTap{uid: tap.UID, expires: now.Add(TapTimeout)}
+		i.mu.Unlock()
+
+		return lnurl.LNURLWithdrawResponse{
+			Tag:                "withdrawRequest",
+			K1:                 k1,
+			Callback:           callback,
+			MinWithdrawable:    1000,
+			MaxWithdrawable:    available,
+			DefaultDescription: description,
+		}, nil
+	})
+}
+
+// Spend is called by the withdraw callback with the k1 it received, before paying the
+// invoice for msats. It returns the UID of the card that was tapped, or an error if k1 is
+// unknown, already used or expired, or if the card can't spend that much. Each k1 can
+// only be used once.
+func (i *Issuer) Spend(k1 string, msats int64) (uid string, err error) {
+	if msats <= 0 {
+		return "", errors.New("amount must be positive")
+	}
+
+	i.mu.Lock()
+	pending, ok := i.pending[k1]
+	delete(i.pending, k1)
+	i.mu.Unlock()
+
+	if !ok || time.Now().After(pending.expires) {
+		return "", errors.New("unknown or expired k1")
+	}
+	if err := i.Cards.Spend(pending.uid, msats, time.Now()); err != nil {
+		return "", err
+	}
+	return pending.uid, nil
+}
@@ -0,0 +1,105 @@
+package boltcard
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/go-lnurl"
+)
+
+// tapURL returns the URL a card programmed with keys and pointing to base would open.
+func tapURL(base string, keys Keys, uid string, counter uint32) string {
+	buid, _ := hex.DecodeString(uid)
+	plain := append([]byte{0xc7}, buid...)
+	plain = append(plain, byte(counter), byte(counter>>8), byte(counter>>16), 1, 2, 3, 4, 5)
+	block, _ := aes.NewCipher(keys.K1)
+	p := make([]byte, 16)
+	block.Encrypt(p, plain)
+
+	sv2 := append([]byte{0x3c, 0xc3, 0x00, 0x01, 0x00, 0x80}, plain[1:11]...)
+	ks, _ := CMAC(keys.K2, sv2)
+	mac, _ := CMAC(ks, nil)
+	c := make([]byte, 8)
+	for i := range c {
+		c[i] = mac[i*2+1]
+	}
+
+	return fmt.Sprintf("%s?p=%X&c=%X", base, p, c)
+}
+
+func TestIssuer(t *testing.T) {
+	k1, _ := NewKeys(nil)
+	issuer := &Issuer{K1: k1.K1, Cards: &MemoryCards{}, Counters: &MemoryCounters{}}
+	s := httptest.NewTLSServer(issuer.Handler("https://service.com/callback", "bolt card"))
+	defer s.Close()
+	previous := lnurl.WithCustomClient(s.Client())
+	defer lnurl.WithCustomClient(previous)
+	issuer.LNURLWBase = s.URL + "/ln"
+
+	uid := "04996C6A926980"
+	card, payload, err := issuer.Issue(uid, "alice", Limits{PerTap: 50000, PerDay: 80000})
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	encoded, _ := json.Marshal(payload)
+	if !strings.Contains(string(encoded), `"protocol_name":"new_bolt_card_response"`) ||
+		payload.LNURLWBase != "lnurlw://"+strings.TrimPrefix(s.URL, "https://")+"/ln" ||
+		payload.K1 != hex.EncodeToString(issuer.K1) || payload.K2 == payload.K1 {
+		t.Errorf("Issue() got payload %s", encoded)
+	}
+
+	tap := func(keys Keys, counter uint32) (lnurl.LNURLWithdrawResponse, error) {
+		_, params, err := lnurl.HandleLNURL(tapURL(payload.LNURLWBase, keys, uid, counter))
+		if err != nil {
+			return lnurl.LNURLWithdrawResponse{}, err
+		}
+		return params.(lnurl.LNURLWithdrawResponse), nil
+	}
+
+	withdraw, err := tap(card.Keys, 1)
+	if err != nil || withdraw.MaxWithdrawable != 50000 {
+		t.Fatalf("tap got = %+v, %v", withdraw, err)
+	}
+	if spender, err := issuer.Spend(withdraw.K1, 50000); err != nil || spender != card.UID {
+		t.Errorf("Spend() got = %s, %v", spender, err)
+	}
+	if _, err := issuer.Spend(withdraw.K1, 1000); err == nil {
+		t.Errorf("Spend() accepted a used k1")
+	}
+
+	withdraw, err = tap(card.Keys, 2)
+	if err != nil || withdraw.MaxWithdrawable != 30000 {
+		t.Fatalf("second tap got = %+v, %v", withdraw, err)
+	}
+	if _, err := issuer.Spend(withdraw.K1, 40000); err != ErrLimit {
+		t.Errorf("Spend() over the daily limit error = %v", err)
+	}
+	if err := issuer.Cards.Spend(uid, -50000, time.Now()); err == nil {
+		t.Errorf("Spend() of a negative amount succeeded")
+	}
+	if available, _ := issuer.Cards.Available(uid, time.Now()); available != 30000 {
+		t.Errorf("Available() got = %d", available)
+	}
+
+	rotated, wipe, payload, err := issuer.Rotate(uid)
+	if err != nil || rotated.Version != 2 || wipe.K2 != hex.EncodeToString(card.Keys.K2) ||
+		payload.K2 != hex.EncodeToString(rotated.Keys.K2) || wipe.Action != "wipe" {
+		t.Fatalf("Rotate() got = %+v, %+v, %+v, %v", rotated, wipe, payload, err)
+	}
+	if _, err := tap(card.Keys, 3); err == nil {
+		t.Errorf("tap with the old keys succeeded")
+	}
+	if _, err := tap(rotated.Keys, 4); err != nil {
+		t.Errorf("tap with the new keys error = %v", err)
+	}
+	noK1 := &Issuer{Cards: &MemoryCards{}, Counters: &MemoryCounters{}}
+	if _, _, err := noK1.Issue(uid, "bob", Limits{}); err == nil {
+		t.Errorf("Issue() without K1 succeeded")
+	}
+}
@@ -0,0 +1,57 @@
+package boltcard
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+)
+
+// CMAC computes the AES-CMAC of msg as defined in RFC 4493, with a 16, 24 or 32-byte key.
+func CMAC(key []byte, msg []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// subkeys
+	k1 := make([]byte, aes.BlockSize)
+	block.Encrypt(k1, k1)
+	shiftSubkey(k1)
+	k2 := append([]byte(nil), k1...)
+	shiftSubkey(k2)
+
+	// the last block is XORed with k1 if complete and padded and XORed with k2 if not
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	last := make([]byte, aes.BlockSize)
+	if n > 0 && len(msg)%aes.BlockSize == 0 {
+		subtle.XORBytes(last, msg[(n-1)*aes.BlockSize:], k1)
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		rest := msg[(n-1)*aes.BlockSize:]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		subtle.XORBytes(last, last, k2)
+	}
+
+	mac := make([]byte, aes.BlockSize)
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(mac, mac, msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(mac, mac)
+	}
+	subtle.XORBytes(mac, mac, last)
+	block.Encrypt(mac, mac)
+	return mac, nil
+}
+
+// shiftSubkey turns L into K1, or K1 into K2, in place.
+func shiftSubkey(k []byte) {
+	msb := k[0] >> 7
+	for i := 0; i < len(k)-1; i++ {
+		k[i] = k[i]<<1 | k[i+1]>>7
+	}
+	k[len(k)-1] <<= 1
+	if msb == 1 {
+		k[len(k)-1] ^= 0x87
+	}
+}
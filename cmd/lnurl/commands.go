@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/fiatjaf/go-lnurl"
+)
+
+func decode(args []string) error {
+	fs := flags("decode")
+	strict := fs.Bool("strict", false, "validate the domain and scheme of the decoded URL")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one lnurl")
+	}
+
+	if *strict {
+		decoded, err := lnurl.LNURLDecodeStrict(fs.Arg(0))
+		if decoded != "" {
+			fmt.Println(decoded)
+		}
+		return err
+	}
+
+	decoded, err := lnurl.LNURLDecode(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Println(decoded)
+	return nil
+}
+
+func encode(args []string) error {
+	fs := flags("encode")
+	strict := fs.Bool("strict", false, "validate the domain and fix the scheme of the URL before encoding")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one URL")
+	}
+
+	if *strict {
+		encoded, err := lnurl.LNURLEncodeStrict(fs.Arg(0))
+		if encoded != "" {
+			fmt.Println(encoded)
+		}
+		return err
+	}
+
+	encoded, err := lnurl.LNURLEncode(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Println(encoded)
+	return nil
+}
+
+func resolve(args []string) error {
+	fs := flags("resolve")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one lnurl")
+	}
+
+	rawurl, params, err := lnurl.HandleLNURL(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	return printJSON(struct {
+		URL    string            `json:"url"`
+		Kind   string            `json:"kind"`
+		Params lnurl.LNURLParams `json:"params"`
+	}{rawurl, params.LNURLKind(), params})
+}
+
+func pay(args []string) error {
+	fs := flags("pay")
+	comment := fs.String("comment", "", "comment to send along with the payment")
+	var payerdata lnurl.PayerDataValues
+	fs.StringVar(&payerdata.FreeName, "name", "", "payer name")
+	fs.StringVar(&payerdata.Email, "email", "", "payer email")
+	fs.StringVar(&payerdata.LightningAddress, "identifier", "", "payer lightning address")
+	fs.StringVar(&payerdata.PubKey, "pubkey", "", "payer hex-encoded public key")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected an lnurl and an amount")
+	}
+
+	msats, err := strconv.ParseInt(fs.Arg(1), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid amount '%s': %w", fs.Arg(1), err)
+	}
+
+	_, params, err := lnurl.HandleLNURL(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	payParams, ok := params.(lnurl.LNURLPayParams)
+	if !ok {
+		return fmt.Errorf("expected lnurl-pay, got %s", params.LNURLKind())
+	}
+
+	if msats < payParams.MinSendable || msats > payParams.MaxSendable {
+		return fmt.Errorf("amount must be between %d and %d msats",
+			payParams.MinSendable, payParams.MaxSendable)
+	}
+
+	var pd *lnurl.PayerDataValues
+	if payerdata != (lnurl.PayerDataValues{}) {
+		pd = &payerdata
+	}
+
+	values, err := payParams.Call(msats, *comment, pd)
+	if err != nil {
+		return err
+	}
+
+	return printJSON(values)
+}
+
+func withdraw(args []string) error {
+	fs := flags("withdraw")
+	balanceNotify := fs.String("notify", "", "balanceNotify URL the service should POST to when the balance changes")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("expected an lnurl and an invoice")
+	}
+
+	_, params, err := lnurl.HandleLNURL(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	withdrawParams, ok := params.(lnurl.LNURLWithdrawResponse)
+	if !ok {
+		return fmt.Errorf("expected lnurl-withdraw, got %s", params.LNURLKind())
+	}
+
+	if err := withdrawParams.CallNotify(fs.Arg(1), *balanceNotify); err != nil {
+		return err
+	}
+	fmt.Println("OK")
+	return nil
+}
+
+func auth(args []string) error {
+	fs := flags("auth")
+	keyHex := fs.String("key", "", "hex-encoded linking private key")
+	fs.Parse(args)
+	if fs.NArg() != 1 || *keyHex == "" {
+		fs.Usage()
+		return errors.New("expected a key and an lnurl")
+	}
+
+	b, err := hex.DecodeString(*keyHex)
+	if err != nil || len(b) != 32 {
+		return errors.New("key must be a 32-byte hex-encoded private key")
+	}
+	key, _ := btcec.PrivKeyFromBytes(b)
+
+	_, params, err := lnurl.HandleLNURL(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	authParams, ok := params.(lnurl.LNURLAuthParams)
+	if !ok {
+		return fmt.Errorf("expected lnurl-auth, got %s", params.LNURLKind())
+	}
+
+	if err := authParams.Call(key); err != nil {
+		return err
+	}
+	fmt.Println("OK")
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fiatjaf/go-lnurl"
+	"github.com/fiatjaf/go-lnurl/conformance"
+)
+
+func lint(args []string) error {
+	fs := flags("lint")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	amount := fs.Int64("amount", 0, "amount in msats to request an invoice for, defaults to minSendable")
+	skipCallback := fs.Bool("skip-callback", false, "don't call the lnurl-pay callback")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected one lnurl")
+	}
+
+	report, err := conformance.Check(fs.Arg(0), conformance.Options{
+		Client:       lnurl.Client,
+		Amount:       *amount,
+		SkipCallback: *skipCallback,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		fmt.Printf("%s (%s)\n", report.URL, report.Tag)
+		for _, v := range report.Violations {
+			fmt.Println("  " + v.String())
+		}
+		if len(report.Violations) == 0 {
+			fmt.Println("  no violations found")
+		}
+	}
+
+	if !report.OK() {
+		return errors.New("service is not conformant")
+	}
+	return nil
+}
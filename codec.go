@@ -14,27 +14,20 @@ import (
 var lud17ValidSchemes = map[string]struct{}{"lnurla": {}, "lnurlp": {}, "lnurlw": {}, "lnurlc": {}, "keyauth": {}}
 
 // LNURLDecode takes a bech32-encoded lnurl string and returns a plain-text https URL.
+// Bech32-encoded lnurls longer than LNURLMaxLength are rejected.
 func LNURLDecode(code string) (string, error) {
-	code = strings.ToLower(code)
-
-	switch {
-	case strings.HasPrefix(code, "lnurl1"):
-		// bech32
-		tag, data, err := decode(code)
-		if err != nil {
-			return "", err
-		}
-
-		if tag != "lnurl" {
-			return "", errors.New("tag is not 'lnurl', but '" + tag + "'")
+	if hasPrefixFold(code, "lnurl1") {
+		// bech32, which doesn't allow mixed case, but lnurls have always been accepted in
+		// any case here
+		if hasMixedCase(code) {
+			code = strings.ToLower(code)
 		}
+		return lnurlDecodeBech32(code)
+	}
 
-		converted, err := convertBits(data, 5, 8, false)
-		if err != nil {
-			return "", err
-		}
+	code = strings.ToLower(code)
 
-		return string(converted), nil
+	switch {
 	case strings.HasPrefix(code, "lnurlp://"),
 		strings.HasPrefix(code, "lnurlw://"),
 		strings.HasPrefix(code, "lnurlc://"),
@@ -55,14 +48,90 @@ func LNURLDecode(code string) (string, error) {
 
 // LNURLEncode takes a plain-text https URL and returns a bech32-encoded uppercased lnurl string.
 func LNURLEncode(actualurl string) (lnurl string, err error) {
-	asbytes := []byte(actualurl)
-	converted, err := convertBits(asbytes, 8, 5, true)
+	return Encode(actualurl)
+}
+
+// lnurlDecodeBech32 decodes an all lowercase or all uppercase bech32-encoded lnurl, with
+// no intermediate allocations for common lnurl lengths.
+func lnurlDecodeBech32(code string) (string, error) {
+	if len(code) > LNURLMaxLength {
+		return "", fmt.Errorf("lnurl is %d characters long, the maximum is %d",
+			len(code), LNURLMaxLength)
+	}
+
+	var buf [512]byte
+	tag, data, enc, err := appendDecode(buf[:0], code)
+	if err != nil {
+		return "", err
+	}
+	if enc != Bech32 {
+		return "", errors.New("lnurl must be bech32-encoded, got " + enc.String())
+	}
+
+	if !strings.EqualFold(tag, "lnurl") {
+		return "", errors.New("tag is not 'lnurl', but '" + strings.ToLower(tag) + "'")
+	}
+
+	converted, err := appendConvertBits(data[:0], data, 5, 8, false)
 	if err != nil {
-		return
+		return "", err
 	}
 
-	lnurl, err = encode("lnurl", converted)
-	return strings.ToUpper(lnurl), err
+	return string(converted), nil
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+func hasMixedCase(s string) bool {
+	hasLower, hasUpper := false, false
+	for i := 0; i < len(s); i++ {
+		hasLower = hasLower || ('a' <= s[i] && s[i] <= 'z')
+		hasUpper = hasUpper || ('A' <= s[i] && s[i] <= 'Z')
+	}
+	return hasLower && hasUpper
+}
+
+// LNURLFallbackURL takes a website URL and a bech32-encoded lnurl and returns a LUD-01
+// fallback link: a URL that opens the website in a browser while carrying the lnurl in
+// its `lightning` query parameter so wallets can extract it.
+func LNURLFallbackURL(website string, lnurl string) (string, error) {
+	if _, err := LNURLDecode(lnurl); err != nil {
+		return "", err
+	}
+
+	parsed, err := url.Parse(website)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return "", errors.New("fallback website must be an http(s) URL: " + website)
+	}
+
+	qs := parsed.Query()
+	qs.Set("lightning", strings.ToUpper(lnurl))
+	parsed.RawQuery = qs.Encode()
+	return parsed.String(), nil
+}
+
+// ExtractFallbackLNURL returns the bech32-encoded lnurl embedded in the `lightning` query
+// parameter of a LUD-01 fallback link, if there is a valid one.
+func ExtractFallbackLNURL(rawurl string) (lnurl string, ok bool) {
+	parsed, err := url.Parse(rawurl)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return "", false
+	}
+
+	lnurl = strings.TrimSpace(parsed.Query().Get("lightning"))
+	if !strings.HasPrefix(strings.ToLower(lnurl), "lnurl1") {
+		return "", false
+	}
+	if _, err := LNURLDecode(lnurl); err != nil {
+		return "", false
+	}
+
+	return lnurl, true
 }
 
 // LURL embeds net/url and adds extra fields ontop
@@ -154,33 +223,24 @@ func LNURLDecodeStrict(code string) (string, error) {
 	switch {
 	case strings.HasPrefix(code, "lnurl1"):
 		// bech32
-		tag, data, err := decode(code)
-		if err != nil {
-			return "", err
-		}
-
-		if tag != "lnurl" {
-			return "", errors.New("tag is not 'lnurl', but '" + tag + "'")
-		}
-
-		converted, err := convertBits(data, 5, 8, false)
+		decoded, err := lnurlDecodeBech32(code)
 		if err != nil {
 			return "", err
 		}
-		u, err := parse(string(converted))
+		u, err := parse(decoded)
 		if err != nil {
-			return string(converted), err
+			return decoded, err
 		}
 		if u.isIp {
 			if u.Scheme != "https" {
-				err := fmt.Errorf("invalid scheme: %s", string(converted))
+				err := fmt.Errorf("invalid scheme: %s", decoded)
 				u.Scheme = "https"
 				return u.String(), err
 			}
 			return u.String(), nil
 		}
 		if !u.isDomain {
-			return string(converted), fmt.Errorf("invalid domain: %s", string(converted))
+			return decoded, fmt.Errorf("invalid domain: %s", decoded)
 		}
 		if setScheme(u) {
 			return u.String(), fmt.Errorf("invalid scheme: %s", u.Scheme)
@@ -284,15 +344,27 @@ func LNURLEncodeStrict(actualurl string) (string, error) {
 	return enc, err
 }
 
+// Encode takes any string and returns it bech32-encoded as an uppercased lnurl string.
+// Strings whose lnurl would be longer than LNURLMaxLength are rejected, as LNURLDecode
+// wouldn't take them back.
 func Encode(s string) (string, error) {
-	asbytes := []byte(s)
-	converted, err := convertBits(asbytes, 8, 5, true)
+	if length := len("lnurl1") + (len(s)*8+4)/5 + 6; length > LNURLMaxLength {
+		return s, fmt.Errorf("lnurl would be %d characters long, the maximum is %d",
+			length, LNURLMaxLength)
+	}
+
+	var buf [1024]byte
+	converted, err := appendConvertBits(buf[:0], s, 8, 5, true)
 	if err != nil {
 		return s, err
 	}
 
-	lnurl, err := encode("lnurl", converted)
-	return strings.ToUpper(lnurl), err
+	// the encoded string goes right after the data in the same buffer
+	encoded, err := appendEncode(converted[len(converted):], "lnurl", converted, Bech32, true)
+	if err != nil {
+		return s, err
+	}
+	return string(encoded), nil
 }
 
 // IsDomainName (from net package) checks if a string is a presentation-format domain name
@@ -297,6 +297,9 @@ func TestLNURLDecode(t *testing.T) {
 		{desc: "RANDOM_STRING_UPPERCASE_ERROR",
 			args: args{code: strings.ToUpper("lnurl1d3h82unvjhypn2")},
 			want: "lnurl", wantErr: false},
+		{desc: "MIXED_CASE",
+			args: args{code: "lnurl1D3H82UNV9E3K7MG347503"},
+			want: "lnurl.com"},
 		{desc: "HTTPS",
 			args: args{code: "https://lnurl.fiatjaf.com"}, // do noting
 			want: "https://lnurl.fiatjaf.com"},
@@ -498,3 +501,46 @@ func TestLNURLEncodeDecodeStrict(t *testing.T) {
 		})
 	}
 }
+
+// TestLNURLFallbackURL will test if fallback links embed and give back the expected lnurl
+func TestLNURLFallbackURL(t *testing.T) {
+	tests := []struct {
+		desc    string
+		website string
+		lnurl   string
+		want    string
+		wantErr bool
+	}{
+		{desc: "LUD1_FALLBACK",
+			website: "https://service.com/giftcard/redeem?id=123",
+			lnurl:   "lnurl1dp68gurn8ghj7ctsdyhxv6tpw34xze3wvdhk6tmkxghkcmn4wfkz7urp0y0q3peg",
+			want:    "https://service.com/giftcard/redeem?id=123&lightning=LNURL1DP68GURN8GHJ7CTSDYHXV6TPW34XZE3WVDHK6TMKXGHKCMN4WFKZ7URP0Y0Q3PEG"},
+		{desc: "INVALID_LNURL_ERROR",
+			website: "https://service.com/", lnurl: "lnurl1invalid", wantErr: true},
+		{desc: "INVALID_WEBSITE_ERROR",
+			website: "ftp://service.com/", lnurl: "LNURL1D3H82UNV9E3K7MG347503", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := LNURLFallbackURL(tt.website, tt.lnurl)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LNURLFallbackURL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("LNURLFallbackURL() got = %v, want %v", got, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+			lnurl, ok := ExtractFallbackLNURL(got)
+			if !ok || lnurl != strings.ToUpper(tt.lnurl) {
+				t.Errorf("ExtractFallbackLNURL() got = %v, %v", lnurl, ok)
+			}
+		})
+	}
+
+	if _, ok := ExtractFallbackLNURL("https://service.com/?lightning=notanlnurl"); ok {
+		t.Errorf("ExtractFallbackLNURL() accepted an invalid lightning parameter")
+	}
+}
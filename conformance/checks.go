@@ -0,0 +1,66 @@
+package conformance
+
+import (
+	"net/url"
+	"regexp"
+
+	"github.com/tidwall/gjson"
+)
+
+var nodeURIRegex = regexp.MustCompile(`^[0-9a-fA-F]{66}@[^:]+:[0-9]+$`)
+
+// checkAuth verifies an lnurl-auth URL, which is never called without a signature.
+func (c *checker) checkAuth(u *url.URL) {
+	query := u.Query()
+	c.checkK1("LUD-04", Error, "k1", query.Get("k1"))
+
+	switch action := query.Get("action"); action {
+	case "", "register", "login", "link", "auth":
+	default:
+		c.report.add(Error, "LUD-04", "invalid-action", "action", "unknown action '%s'", action)
+	}
+}
+
+func (c *checker) checkWithdraw(j gjson.Result) {
+	c.checkCallback("LUD-03", j)
+	c.checkK1("LUD-03", Warning, "k1", j.Get("k1").String())
+
+	min, okMin := c.checkNumber("LUD-03", j, "minWithdrawable")
+	max, okMax := c.checkNumber("LUD-03", j, "maxWithdrawable")
+	if okMin && min < 0 {
+		c.report.add(Error, "LUD-03", "invalid-amount", "minWithdrawable", "minWithdrawable can't be negative")
+	}
+	if okMax && max <= 0 {
+		c.report.add(Error, "LUD-03", "invalid-amount", "maxWithdrawable", "maxWithdrawable must be positive")
+	}
+	if okMin && okMax && min > max {
+		c.report.add(Error, "LUD-03", "min-max", "minWithdrawable",
+			"minWithdrawable (%d) is greater than maxWithdrawable (%d)", min, max)
+	}
+
+	if description := j.Get("defaultDescription"); !description.Exists() {
+		c.report.add(Error, "LUD-03", "missing-field", "defaultDescription", "defaultDescription is missing")
+	} else if description.Type != gjson.String {
+		c.report.add(Error, "LUD-03", "wrong-type", "defaultDescription", "defaultDescription must be a string")
+	}
+
+	for _, field := range []struct{ name, lud string }{{"balanceCheck", "LUD-14"}, {"payLink", "LUD-19"}} {
+		if value := j.Get(field.name); value.Exists() {
+			if u, err := url.Parse(value.String()); err != nil || u.Scheme == "" {
+				c.report.add(Error, field.lud, "invalid-url", field.name, "%s is not a valid URL: '%s'", field.name, value.String())
+			}
+		}
+	}
+}
+
+func (c *checker) checkChannel(j gjson.Result) {
+	c.checkCallback("LUD-02", j)
+	c.checkK1("LUD-02", Warning, "k1", j.Get("k1").String())
+
+	uri := j.Get("uri").String()
+	if uri == "" {
+		c.report.add(Error, "LUD-02", "missing-field", "uri", "uri is missing")
+	} else if !nodeURIRegex.MatchString(uri) {
+		c.report.add(Error, "LUD-02", "invalid-uri", "uri", "uri must be in the form node_key@ip_address:port_number, got '%s'", uri)
+	}
+}
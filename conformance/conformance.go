@@ -0,0 +1,293 @@
+// Package conformance exercises lnurl service endpoints and reports, LUD by LUD, where
+// they deviate from the specs.
+//
+// The lnurl package itself parses responses leniently so wallets keep working with
+// slightly broken services; this package is the strict counterpart, meant for service
+// operators and for onboarding partners.
+package conformance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fiatjaf/go-lnurl"
+	"github.com/tidwall/gjson"
+)
+
+type Severity string
+
+const (
+	// Error is a violation of a MUST in the spec, wallets are likely to fail.
+	Error Severity = "error"
+	// Warning is a violation of a SHOULD or a likely mistake.
+	Warning Severity = "warning"
+)
+
+// Violation is a single problem found in a service.
+type Violation struct {
+	LUD      string   `json:"lud"`
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Field    string   `json:"field,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Field != "" {
+		return fmt.Sprintf("%s %s [%s] %s: %s", v.Severity, v.LUD, v.Check, v.Field, v.Message)
+	}
+	return fmt.Sprintf("%s %s [%s] %s", v.Severity, v.LUD, v.Check, v.Message)
+}
+
+// Report is the machine-readable result of a conformance check.
+type Report struct {
+	Target     string      `json:"target"`
+	URL        string      `json:"url,omitempty"`
+	Tag        string      `json:"tag,omitempty"`
+	Violations []Violation `json:"violations"`
+}
+
+// OK tells whether the service passed with no errors, warnings are allowed.
+func (r *Report) OK() bool {
+	for _, v := range r.Violations {
+		if v.Severity == Error {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Report) add(severity Severity, lud, check, field, format string, args ...interface{}) {
+	r.Violations = append(r.Violations, Violation{
+		LUD:      lud,
+		Check:    check,
+		Severity: severity,
+		Field:    field,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// Options changes how a service is exercised.
+type Options struct {
+	// Client is used for all requests, defaults to a client with a 10 second timeout.
+	Client *http.Client
+
+	// Amount is the amount in millisatoshis to request an invoice for when checking
+	// lnurl-pay callbacks, defaults to minSendable.
+	Amount int64
+
+	// SkipCallback disables calling the callback of lnurl-pay services, so no invoice
+	// is generated.
+	SkipCallback bool
+}
+
+type checker struct {
+	opts   Options
+	report *Report
+}
+
+// Check resolves an lnurl, LUD-17 URL or lightning address, calls the service and returns
+// a report of all the violations found. An error is returned only when the target can't
+// be understood at all; problems with the service itself are reported as violations.
+func Check(target string, opts Options) (*Report, error) {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	c := &checker{opts: opts, report: &Report{Target: target, Violations: []Violation{}}}
+
+	rawurl, lightningAddress, err := c.resolve(target)
+	if err != nil {
+		return nil, err
+	}
+	c.report.URL = rawurl
+
+	parsed, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL '%s': %w", rawurl, err)
+	}
+	c.checkSecure("LUD-01", "url", parsed)
+
+	if parsed.Query().Get("tag") == "login" {
+		c.report.Tag = "login"
+		c.checkAuth(parsed)
+		return c.report, nil
+	}
+
+	b, ok := c.get("LUD-01", parsed)
+	if !ok {
+		return c.report, nil
+	}
+
+	j := gjson.ParseBytes(b)
+	if j.Get("status").String() == "ERROR" {
+		c.report.add(Error, "LUD-01", "error-status", "status",
+			"service responded with an error: %s", j.Get("reason").String())
+		return c.report, nil
+	}
+
+	c.report.Tag = j.Get("tag").String()
+	switch c.report.Tag {
+	case "payRequest":
+		c.checkPay(j, lightningAddress)
+	case "withdrawRequest":
+		c.checkWithdraw(j)
+	case "channelRequest":
+		c.checkChannel(j)
+	case "":
+		c.report.add(Error, "LUD-01", "missing-field", "tag", "response has no tag")
+	default:
+		c.report.add(Error, "LUD-01", "unknown-tag", "tag", "unknown tag '%s'", c.report.Tag)
+	}
+
+	return c.report, nil
+}
+
+// resolve turns the target into the URL that should be called first.
+func (c *checker) resolve(target string) (rawurl string, lightningAddress bool, err error) {
+	target = strings.TrimSpace(target)
+	if strings.HasPrefix(strings.ToLower(target), "lightning:") {
+		target = target[len("lightning:"):]
+	}
+
+	if name, domain, ok := lnurl.ParseInternetIdentifier(target); ok {
+		scheme := "https://"
+		if strings.HasSuffix(domain, ".onion") {
+			scheme = "http://"
+		}
+		return scheme + domain + "/.well-known/lnurlp/" + name, true, nil
+	}
+
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		if embedded, ok := lnurl.ExtractFallbackLNURL(target); ok {
+			target = embedded
+		} else {
+			return target, false, nil
+		}
+	}
+
+	if strings.HasPrefix(strings.ToLower(target), "lnurl1") {
+		rawurl, err := lnurl.LNURLDecode(target)
+		return rawurl, false, err
+	}
+
+	for _, scheme := range []string{"lnurlp", "lnurlw", "lnurlc", "keyauth"} {
+		if strings.HasPrefix(target, scheme+"://") {
+			location := target[len(scheme)+3:]
+			host := strings.SplitN(location, "/", 2)[0]
+			if strings.HasSuffix(host, ".onion") {
+				return "http://" + location, false, nil
+			}
+			return "https://" + location, false, nil
+		}
+	}
+
+	return "", false, errors.New("unrecognized lnurl format: " + target)
+}
+
+// get calls a URL and checks the HTTP-level requirements, returning the body only if it
+// can be processed further.
+func (c *checker) get(lud string, u *url.URL) ([]byte, bool) {
+	resp, err := c.opts.Client.Get(u.String())
+	if err != nil {
+		c.report.add(Error, lud, "unreachable", "", "failed to call '%s': %s", u, err)
+		return nil, false
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		c.report.add(Error, lud, "unreachable", "", "failed to read response from '%s': %s", u, err)
+		return nil, false
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		c.report.add(Error, lud, "status-code", "",
+			"'%s' responded with HTTP status %d, expected 200", u, resp.StatusCode)
+	}
+
+	if mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediatype != "application/json" {
+		c.report.add(Warning, lud, "content-type", "",
+			"'%s' responded with content-type '%s', expected application/json", u, resp.Header.Get("Content-Type"))
+	}
+
+	if !json.Valid(b) || !gjson.ParseBytes(b).IsObject() {
+		c.report.add(Error, lud, "invalid-json", "", "'%s' responded with something that is not a JSON object: %.200s", u, b)
+		return nil, false
+	}
+
+	return b, true
+}
+
+// checkSecure reports URLs that are not https, except for onion services.
+func (c *checker) checkSecure(lud string, field string, u *url.URL) bool {
+	if strings.HasSuffix(u.Hostname(), ".onion") {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			c.report.add(Error, lud, "insecure-url", field, "onion URL must be http or https, got '%s'", u.Scheme)
+			return false
+		}
+		return true
+	}
+	if u.Scheme != "https" {
+		c.report.add(Error, lud, "insecure-url", field, "URL must be https for clearnet domains, got '%s'", u)
+		return false
+	}
+	return true
+}
+
+// checkCallback checks a callback field is an absolute https URL. Insecure callbacks are
+// reported and not returned, as wallets shouldn't call them.
+func (c *checker) checkCallback(lud string, j gjson.Result) *url.URL {
+	callback := j.Get("callback")
+	if !callback.Exists() || callback.String() == "" {
+		c.report.add(Error, lud, "missing-field", "callback", "callback is missing")
+		return nil
+	}
+	if callback.Type != gjson.String {
+		c.report.add(Error, lud, "wrong-type", "callback", "callback must be a string")
+		return nil
+	}
+
+	u, err := url.Parse(callback.String())
+	if err != nil || u.Host == "" {
+		c.report.add(Error, lud, "invalid-url", "callback", "callback is not an absolute URL: '%s'", callback.String())
+		return nil
+	}
+	if !c.checkSecure(lud, "callback", u) {
+		return nil
+	}
+	return u
+}
+
+// checkNumber checks a field exists and is an integer, returning its value.
+func (c *checker) checkNumber(lud string, j gjson.Result, field string) (int64, bool) {
+	value := j.Get(field)
+	if !value.Exists() {
+		c.report.add(Error, lud, "missing-field", field, "%s is missing", field)
+		return 0, false
+	}
+	if value.Type != gjson.Number || value.Num != float64(value.Int()) {
+		c.report.add(Error, lud, "wrong-type", field, "%s must be an integer, got %s", field, value.Raw)
+		return 0, false
+	}
+	return value.Int(), true
+}
+
+// checkK1 checks a field is a 32-byte hex string, as required for k1 in lnurl-auth and
+// recommended elsewhere.
+func (c *checker) checkK1(lud string, severity Severity, field string, k1 string) {
+	if k1 == "" {
+		c.report.add(Error, lud, "missing-field", field, "%s is missing", field)
+		return
+	}
+	if len(k1) != 64 || strings.Trim(strings.ToLower(k1), "0123456789abcdef") != "" {
+		c.report.add(severity, lud, "invalid-k1", field, "%s should be a 32-byte hex-encoded string, got '%s'", field, k1)
+	}
+}
@@ -0,0 +1,197 @@
+package conformance
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+func makeInvoice(t *testing.T, msats int64, description string) string {
+	key, _ := btcec.NewPrivateKey()
+	inv, err := zpay32.NewInvoice(&chaincfg.MainNetParams, sha256.Sum256([]byte(description)), time.Now(),
+		zpay32.Amount(lnwire.MilliSatoshi(msats)),
+		zpay32.DescriptionHash(sha256.Sum256([]byte(description))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := inv.Encode(zpay32.MessageSigner{SignCompact: func(msg []byte) ([]byte, error) {
+		return ecdsa.SignCompact(key, chainhash.HashB(msg), true)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pr
+}
+
+func TestCheckPay(t *testing.T) {
+	goodMetadata := `[["text/plain","a coffee"],["text/identifier","coffee@127.0.0.1"]]`
+
+	tests := []struct {
+		desc     string
+		params   map[string]interface{}
+		values   func(msats int64) map[string]interface{}
+		status   int
+		target   string
+		wantErrs []string
+	}{
+		{desc: "CONFORMANT"},
+		{desc: "MIN_GREATER_THAN_MAX",
+			params:   map[string]interface{}{"minSendable": 5000, "maxSendable": 1000},
+			wantErrs: []string{"min-max"}},
+		{desc: "MISSING_FIELDS",
+			params:   map[string]interface{}{"callback": nil, "maxSendable": nil},
+			wantErrs: []string{"missing-field", "missing-field"}},
+		{desc: "MALFORMED_METADATA",
+			params:   map[string]interface{}{"metadata": `[["text/plain","a"],["text/plain","b"],["image/png;base64","%%%"]]`},
+			wantErrs: []string{"malformed-metadata", "malformed-metadata"}},
+		{desc: "METADATA_NOT_A_STRING",
+			params:   map[string]interface{}{"metadata": [][]string{{"text/plain", "a"}}},
+			wantErrs: []string{"wrong-type"}},
+		{desc: "LIGHTNING_ADDRESS_WITHOUT_IDENTIFIER",
+			params:   map[string]interface{}{"metadata": `[["text/plain","a coffee"]]`},
+			target:   "coffee@",
+			wantErrs: []string{"malformed-metadata"}},
+		{desc: "DESCRIPTION_HASH_MISMATCH",
+			values: func(msats int64) map[string]interface{} {
+				return map[string]interface{}{"pr": makeInvoice(t, msats, "something else"), "routes": []string{}}
+			},
+			wantErrs: []string{"description-hash"}},
+		{desc: "WRONG_AMOUNT",
+			values: func(msats int64) map[string]interface{} {
+				return map[string]interface{}{"pr": makeInvoice(t, msats+1000, goodMetadata), "routes": []string{}}
+			},
+			wantErrs: []string{"invoice-amount"}},
+		{desc: "MISSING_ROUTES",
+			values: func(msats int64) map[string]interface{} {
+				return map[string]interface{}{"pr": makeInvoice(t, msats, goodMetadata)}
+			}},
+		{desc: "INVALID_SUCCESS_ACTION",
+			values: func(msats int64) map[string]interface{} {
+				return map[string]interface{}{
+					"pr":            makeInvoice(t, msats, goodMetadata),
+					"routes":        []string{},
+					"successAction": map[string]string{"tag": "url", "url": "https://elsewhere.com/", "description": "x"},
+				}
+			},
+			wantErrs: []string{"invalid-success-action"}},
+		{desc: "INSECURE_CALLBACK",
+			params:   map[string]interface{}{"callback": "http://example.com/callback"},
+			wantErrs: []string{"insecure-url"}},
+		{desc: "WRONG_STATUS_CODE",
+			status:   http.StatusCreated,
+			wantErrs: []string{"status-code", "status-code"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			var server *httptest.Server
+			server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+
+				metadata := goodMetadata
+				if m, ok := tt.params["metadata"].(string); ok {
+					metadata = m
+				}
+
+				if r.URL.Path == "/callback" {
+					msats, _ := strconv.ParseInt(r.URL.Query().Get("amount"), 10, 64)
+					values := map[string]interface{}{"pr": makeInvoice(t, msats, metadata), "routes": []string{}}
+					if tt.values != nil {
+						values = tt.values(msats)
+					}
+					json.NewEncoder(w).Encode(values)
+					return
+				}
+
+				params := map[string]interface{}{
+					"tag":         "payRequest",
+					"callback":    server.URL + "/callback",
+					"minSendable": 1000,
+					"maxSendable": 100000,
+					"metadata":    metadata,
+				}
+				for k, v := range tt.params {
+					if v == nil {
+						delete(params, k)
+					} else {
+						params[k] = v
+					}
+				}
+				json.NewEncoder(w).Encode(params)
+			}))
+			defer server.Close()
+
+			target := server.URL + "/.well-known/lnurlp/coffee"
+			if tt.target != "" {
+				target = tt.target + strings.TrimPrefix(server.URL, "https://")
+			}
+
+			report, err := Check(target, Options{Client: server.Client()})
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+
+			var got []string
+			for _, v := range report.Violations {
+				if v.Severity == Error {
+					got = append(got, v.Check)
+				}
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.wantErrs, ",") {
+				t.Errorf("Check() got errors %v, want %v", report.Violations, tt.wantErrs)
+			}
+			if report.OK() != (len(tt.wantErrs) == 0) {
+				t.Errorf("Report.OK() got = %v", report.OK())
+			}
+		})
+	}
+}
+
+func TestCheckWithdraw(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"tag":             "withdrawRequest",
+			"callback":        "http://example.com/callback",
+			"k1":              "xyz",
+			"minWithdrawable": 2000,
+			"maxWithdrawable": 1000.5,
+		})
+	}))
+	defer server.Close()
+
+	report, err := Check(server.URL, Options{Client: server.Client()})
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+
+	b, _ := json.Marshal(report)
+	for _, want := range []string{
+		`"check":"invalid-k1","severity":"warning"`,
+		`"lud":"LUD-03","check":"insecure-url","severity":"error","field":"callback"`,
+		`"check":"wrong-type","severity":"error","field":"maxWithdrawable"`,
+		`"check":"missing-field","severity":"error","field":"defaultDescription"`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("Check() report %s doesn't contain %s", b, want)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package conformance
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+	"github.com/tidwall/gjson"
+)
+
+func (c *checker) checkPay(j gjson.Result, lightningAddress bool) {
+	callback := c.checkCallback("LUD-06", j)
+
+	min, okMin := c.checkNumber("LUD-06", j, "minSendable")
+	max, okMax := c.checkNumber("LUD-06", j, "maxSendable")
+	if okMin && min <= 0 {
+		c.report.add(Error, "LUD-06", "invalid-amount", "minSendable", "minSendable must be positive")
+	}
+	if okMax && max <= 0 {
+		c.report.add(Error, "LUD-06", "invalid-amount", "maxSendable", "maxSendable must be positive")
+	}
+	if okMin && okMax && min > max {
+		c.report.add(Error, "LUD-06", "min-max", "minSendable",
+			"minSendable (%d) is greater than maxSendable (%d)", min, max)
+	}
+
+	metadata := j.Get("metadata")
+	metadataOK := false
+	if !metadata.Exists() {
+		c.report.add(Error, "LUD-06", "missing-field", "metadata", "metadata is missing")
+	} else if metadata.Type != gjson.String {
+		c.report.add(Error, "LUD-06", "wrong-type", "metadata", "metadata must be a string containing a JSON array, not the array itself")
+	} else {
+		metadataOK = c.checkMetadata(metadata.String(), lightningAddress)
+	}
+
+	if comment := j.Get("commentAllowed"); comment.Exists() {
+		if n, ok := c.checkNumber("LUD-12", j, "commentAllowed"); ok && n < 0 {
+			c.report.add(Error, "LUD-12", "invalid-comment-allowed", "commentAllowed", "commentAllowed can't be negative")
+		}
+	}
+
+	if payerData := j.Get("payerData"); payerData.Exists() {
+		if !payerData.IsObject() {
+			c.report.add(Error, "LUD-18", "wrong-type", "payerData", "payerData must be an object")
+		} else if auth := payerData.Get("auth"); auth.Exists() {
+			c.checkK1("LUD-18", Error, "payerData.auth.k1", auth.Get("k1").String())
+		}
+	}
+
+	if c.opts.SkipCallback || callback == nil || !okMin || !okMax || !metadataOK || min > max {
+		return
+	}
+
+	amount := c.opts.Amount
+	if amount == 0 {
+		amount = min
+	}
+
+	qs := callback.Query()
+	qs.Set("amount", strconv.FormatInt(amount, 10))
+	callback.RawQuery = qs.Encode()
+
+	b, ok := c.get("LUD-06", callback)
+	if !ok {
+		return
+	}
+
+	values := gjson.ParseBytes(b)
+	if values.Get("status").String() == "ERROR" {
+		c.report.add(Error, "LUD-06", "error-status", "status",
+			"callback responded with an error for amount %d: %s", amount, values.Get("reason").String())
+		return
+	}
+
+	// wallets don't rely on routes anymore, so only a wrong value is an error
+	if routes := values.Get("routes"); !routes.Exists() {
+		c.report.add(Warning, "LUD-06", "missing-field", "routes", "routes should be present as an empty array")
+	} else if !routes.IsArray() {
+		c.report.add(Error, "LUD-06", "wrong-type", "routes", "routes must be an array, even if empty")
+	}
+
+	if disposable := values.Get("disposable"); disposable.Exists() && !disposable.IsBool() {
+		c.report.add(Error, "LUD-11", "wrong-type", "disposable", "disposable must be a boolean")
+	}
+
+	if sa := values.Get("successAction"); sa.Exists() && sa.Type != gjson.Null {
+		c.checkSuccessAction(sa, callback)
+	}
+
+	pr := values.Get("pr").String()
+	if pr == "" {
+		c.report.add(Error, "LUD-06", "missing-field", "pr", "callback response has no invoice")
+		return
+	}
+
+	inv, err := decodepay.Decodepay(pr)
+	if err != nil {
+		c.report.add(Error, "LUD-06", "invalid-invoice", "pr", "invoice can't be decoded: %s", err)
+		return
+	}
+
+	if inv.MSatoshi != amount {
+		c.report.add(Error, "LUD-06", "invoice-amount", "pr",
+			"invoice amount is %d msat, but %d was requested", inv.MSatoshi, amount)
+	}
+
+	hash := sha256.Sum256([]byte(metadata.String()))
+	if inv.DescriptionHash != hex.EncodeToString(hash[:]) {
+		c.report.add(Error, "LUD-06", "description-hash", "pr",
+			"invoice description hash is '%s', but sha256(metadata) is '%x'", inv.DescriptionHash, hash)
+	}
+}
+
+// checkMetadata returns false if the metadata is so broken that calling the callback
+// would be pointless.
+func (c *checker) checkMetadata(encoded string, lightningAddress bool) bool {
+	parsed := gjson.Parse(encoded)
+	if !gjson.Valid(encoded) || !parsed.IsArray() {
+		c.report.add(Error, "LUD-06", "malformed-metadata", "metadata", "metadata is not a JSON array")
+		return false
+	}
+
+	counts := make(map[string]int)
+	for i, entry := range parsed.Array() {
+		items := entry.Array()
+		if !entry.IsArray() || len(items) != 2 || items[0].Type != gjson.String || items[1].Type != gjson.String {
+			c.report.add(Error, "LUD-06", "malformed-metadata", "metadata",
+				"entry %d is not a [mime, string] pair: %s", i, entry.Raw)
+			continue
+		}
+
+		mimetype, value := items[0].String(), items[1].String()
+		switch {
+		case mimetype == "image/png;base64" || mimetype == "image/jpeg;base64":
+			counts["image"]++
+			if _, err := base64.StdEncoding.DecodeString(value); err != nil {
+				c.report.add(Error, "LUD-06", "malformed-metadata", "metadata",
+					"%s entry is not valid base64: %s", mimetype, err)
+			}
+		case strings.HasPrefix(mimetype, "image/"):
+			c.report.add(Warning, "LUD-06", "malformed-metadata", "metadata",
+				"image type '%s' is not one of image/png;base64 or image/jpeg;base64", mimetype)
+		default:
+			counts[mimetype]++
+		}
+	}
+
+	switch counts["text/plain"] {
+	case 0:
+		c.report.add(Error, "LUD-06", "malformed-metadata", "metadata", "text/plain entry is missing")
+	case 1:
+	default:
+		c.report.add(Error, "LUD-06", "malformed-metadata", "metadata", "there must be only one text/plain entry")
+	}
+	if counts["text/long-desc"] > 1 {
+		c.report.add(Error, "LUD-06", "malformed-metadata", "metadata", "there must be at most one text/long-desc entry")
+	}
+	if counts["image"] > 1 {
+		c.report.add(Error, "LUD-06", "malformed-metadata", "metadata", "there must be at most one image entry")
+	}
+	if lightningAddress && counts["text/identifier"]+counts["text/email"] == 0 {
+		c.report.add(Error, "LUD-16", "malformed-metadata", "metadata",
+			"lightning address metadata must contain a text/identifier or text/email entry")
+	}
+
+	return true
+}
+
+func (c *checker) checkSuccessAction(sa gjson.Result, callback *url.URL) {
+	if !sa.IsObject() {
+		c.report.add(Error, "LUD-09", "invalid-success-action", "successAction", "successAction must be an object or null")
+		return
+	}
+
+	checkLength := func(field string, max int) {
+		if n := utf8.RuneCountInString(sa.Get(field).String()); n > max {
+			c.report.add(Error, "LUD-09", "invalid-success-action", "successAction."+field,
+				"%s is %d characters long, the maximum is %d", field, n, max)
+		}
+	}
+
+	switch tag := sa.Get("tag").String(); tag {
+	case "message":
+		checkLength("message", 144)
+	case "url":
+		checkLength("description", 144)
+		u, err := url.Parse(sa.Get("url").String())
+		if err != nil || u.Host == "" {
+			c.report.add(Error, "LUD-09", "invalid-success-action", "successAction.url", "url is not an absolute URL")
+		} else if u.Hostname() != callback.Hostname() {
+			c.report.add(Error, "LUD-09", "invalid-success-action", "successAction.url",
+				"url domain '%s' is not the same as the callback domain '%s'", u.Hostname(), callback.Hostname())
+		}
+	case "aes":
+		checkLength("description", 144)
+		ciphertext := sa.Get("ciphertext").String()
+		if _, err := base64.StdEncoding.DecodeString(ciphertext); err != nil || ciphertext == "" {
+			c.report.add(Error, "LUD-10", "invalid-success-action", "successAction.ciphertext", "ciphertext is not valid base64")
+		} else if len(ciphertext) > 4096 {
+			c.report.add(Error, "LUD-10", "invalid-success-action", "successAction.ciphertext", "ciphertext is longer than 4kb")
+		}
+		iv := sa.Get("iv").String()
+		if b, err := base64.StdEncoding.DecodeString(iv); err != nil || len(iv) != 24 || len(b) != 16 {
+			c.report.add(Error, "LUD-10", "invalid-success-action", "successAction.iv", "iv must be 16 bytes encoded as 24 base64 characters")
+		}
+	default:
+		c.report.add(Error, "LUD-09", "invalid-success-action", "successAction.tag", "unknown successAction tag '%s'", tag)
+	}
+}
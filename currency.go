@@ -0,0 +1,233 @@
+package lnurl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Currency is a currency an lnurl-pay service accepts amounts in, as listed in the
+// currencies field of its payRequest.
+type Currency struct {
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Symbol string `json:"symbol"`
+
+	// Multiplier is how many msats one unit of the currency, in its smallest unit, is
+	// worth.
+	Multiplier float64 `json:"multiplier"`
+
+	// Decimals is the number of digits after the decimal point of the smallest unit, 2 for
+	// cents.
+	Decimals int `json:"decimals"`
+
+	// Convertible bounds the amounts accepted, in the smallest unit.
+	Convertible struct {
+		Min int64 `json:"min"`
+		Max int64 `json:"max"`
+	} `json:"convertible"`
+}
+
+// Converted is how a service converted an amount in another currency to the amount of the
+// invoice it issued.
+type Converted struct {
+	Amount       int64   `json:"amount"`
+	CurrencyCode string  `json:"currencyCode"`
+	Decimals     int     `json:"decimals"`
+	Multiplier   float64 `json:"multiplier"`
+
+	// Fee is in msats, it is added to the converted amount.
+	Fee int64 `json:"fee"`
+}
+
+// Convert converts amount, in the smallest unit of the currency, adding fee msats.
+func (c Currency) Convert(amount int64, fee int64) Converted {
+	return Converted{
+		Amount:       amount,
+		CurrencyCode: c.Code,
+		Decimals:     c.Decimals,
+		Multiplier:   c.Multiplier,
+		Fee:          fee,
+	}
+}
+
+// MSats is the amount the invoice must have.
+func (c Converted) MSats() int64 {
+	return int64(math.Round(float64(c.Amount)*c.Multiplier)) + c.Fee
+}
+
+// ParseCurrencyAmount parses the amount parameter of a pay callback, which is either msats
+// or, like 1000.USD, an amount in the smallest unit of a currency followed by its code.
+func ParseCurrencyAmount(s string) (amount int64, currency string, err error) {
+	number, currency, hasCurrency := strings.Cut(s, ".")
+	amount, err = strconv.ParseInt(number, 10, 64)
+	if err != nil || amount < 0 || (hasCurrency && currency == "") {
+		return 0, "", errors.New("invalid amount " + s)
+	}
+	return amount, currency, nil
+}
+
+// CurrencyTolerance is how far, as a fraction, the amount of an invoice for an amount in
+// another currency can be from what the advertised multiplier gives, as rates move.
+var CurrencyTolerance = 0.01
+
+// Validate checks the currency has a code, a positive multiplier and sensible bounds.
+func (c Currency) Validate() error {
+	if c.Code == "" || strings.ContainsAny(c.Code, ". ") {
+		return fmt.Errorf("invalid currency code '%s'", c.Code)
+	}
+	if !(c.Multiplier > 0) || math.IsInf(c.Multiplier, 0) {
+		return fmt.Errorf("%s has an invalid multiplier %v", c.Code, c.Multiplier)
+	}
+	if c.Decimals < 0 || c.Decimals > 18 {
+		return fmt.Errorf("%s has an invalid number of decimals %d", c.Code, c.Decimals)
+	}
+	if c.Convertible.Min < 0 || c.Convertible.Max < c.Convertible.Min {
+		return fmt.Errorf("%s has invalid bounds %d-%d", c.Code, c.Convertible.Min, c.Convertible.Max)
+	}
+	return nil
+}
+
+// Currency returns the currency with the given code the service accepts.
+func (params LNURLPayParams) Currency(code string) (Currency, bool) {
+	for _, c := range params.Currencies {
+		if c.Code == code {
+			return c, true
+		}
+	}
+	return Currency{}, false
+}
+
+// CallCurrency is like Call, but with amount in the smallest unit of one of the
+// currencies of the service. The amount of the invoice must match what the advertised
+// multiplier gives, within CurrencyTolerance, plus the fee reported in the conversion, if
+// the service returns one, which can be at most maxFee msats.
+func (params LNURLPayParams) CallCurrency(
+	amount int64,
+	code string,
+	maxFee int64,
+	comment string,
+	payerdata *PayerDataValues,
+) (*LNURLPayValues, error) {
+	currency, ok := params.Currency(code)
+	if !ok {
+		return nil, fmt.Errorf("service doesn't accept %s", code)
+	}
+	if amount < currency.Convertible.Min || amount > currency.Convertible.Max {
+		return nil, fmt.Errorf("amount must be between %d and %d", currency.Convertible.Min, currency.Convertible.Max)
+	}
+
+	payerdata, err := params.checkCall(comment, payerdata)
+	if err != nil {
+		return nil, err
+	}
+	values, err := callPay(params.CallbackURL(), payCall{
+		comment:   comment,
+		payerdata: payerdata,
+		amount:    amount,
+		currency:  code,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msats := int64(values.ParsedInvoice.MSatoshi)
+	if converted := values.Converted; converted != nil {
+		if converted.CurrencyCode != code || converted.Amount != amount {
+			return nil, errors.New("service converted another amount than the one requested")
+		}
+		if converted.Fee < 0 || converted.Fee > maxFee {
+			return nil, fmt.Errorf("service charges a fee of %d msats, the maximum is %d", converted.Fee, maxFee)
+		}
+		if msats != converted.MSats() {
+			return nil, fmt.Errorf("got invoice with wrong amount (wanted %d, got %d)", converted.MSats(), msats)
+		}
+		msats -= converted.Fee
+	}
+
+	expected := float64(amount) * currency.Multiplier
+	if math.Abs(float64(msats)-expected) > expected*CurrencyTolerance+1 {
+		return nil, fmt.Errorf("got invoice for %d msats, %s %d is worth %.0f", msats, code, amount, expected)
+	}
+	return values, nil
+}
+
+// RateProvider gives the exchange rates of the currencies a service accepts.
+type RateProvider interface {
+	// Rate returns how many msats one unit of currency, in its smallest unit, is worth.
+	Rate(currency string) (float64, error)
+}
+
+// CurrencyServer lets an lnurl-pay service accept amounts in Currencies, converted with
+// the current rates: Advertise lists them in the payRequest and Convert reads the amount
+// sent to the callback.
+type CurrencyServer struct {
+	// Currencies are advertised with the multiplier given by Rates. Bounds left empty are
+	// derived from MinSendable and MaxSendable.
+	Currencies []Currency
+	Rates      RateProvider
+
+	// Fee is added, in msats, to every conversion.
+	Fee int64
+}
+
+// Advertise sets the currencies of params, with their current rates. Currencies whose
+// rate can't be obtained, or that end up invalid, are left out.
+func (cs *CurrencyServer) Advertise(params *LNURLPayParams) {
+	params.Currencies = make([]Currency, 0, len(cs.Currencies))
+	for _, currency := range cs.Currencies {
+		if currency, err := cs.currency(currency, *params); err == nil {
+			params.Currencies = append(params.Currencies, currency)
+		}
+	}
+}
+
+// Convert reads the amount parameter received by the callback of params. Amounts in msats
+// are returned as they are, with a nil conversion.
+func (cs *CurrencyServer) Convert(amountParam string, params LNURLPayParams) (int64, *Converted, error) {
+	amount, code, err := ParseCurrencyAmount(amountParam)
+	if err != nil {
+		return 0, nil, err
+	}
+	if code == "" {
+		return amount, nil, nil
+	}
+
+	var currency Currency
+	for _, c := range cs.Currencies {
+		if c.Code == code {
+			currency = c
+		}
+	}
+	if currency.Code == "" {
+		return 0, nil, fmt.Errorf("%s is not accepted", code)
+	}
+	if currency, err = cs.currency(currency, params); err != nil {
+		return 0, nil, err
+	}
+	if amount < currency.Convertible.Min || amount > currency.Convertible.Max {
+		return 0, nil, fmt.Errorf("amount must be between %d and %d", currency.Convertible.Min, currency.Convertible.Max)
+	}
+
+	converted := currency.Convert(amount, cs.Fee)
+	return converted.MSats(), &converted, nil
+}
+
+// currency returns currency with its current rate and its bounds.
+func (cs *CurrencyServer) currency(currency Currency, params LNURLPayParams) (Currency, error) {
+	rate, err := cs.Rates.Rate(currency.Code)
+	if err != nil {
+		return Currency{}, fmt.Errorf("failed to get the rate of %s: %w", currency.Code, err)
+	}
+	if !(rate > 0) {
+		return Currency{}, fmt.Errorf("got an invalid rate for %s: %v", currency.Code, rate)
+	}
+	currency.Multiplier = rate
+	if currency.Convertible.Max == 0 {
+		currency.Convertible.Min = int64(math.Ceil(float64(params.MinSendable) / rate))
+		currency.Convertible.Max = int64(math.Floor(float64(params.MaxSendable-cs.Fee) / rate))
+	}
+	return currency, currency.Validate()
+}
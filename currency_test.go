@@ -0,0 +1,123 @@
+package lnurl
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCallCurrency(t *testing.T) {
+	rates := fixedRates{"USD": 20000, "EUR": 22000}
+	currencies := &CurrencyServer{
+		Currencies: []Currency{{Code: "USD", Decimals: 2}, {Code: "EUR", Decimals: 2}, {Code: "JPY"}},
+		Rates:      rates,
+	}
+	var callbacks []url.Values
+	var callback string
+	params := func() LNURLPayParams {
+		params := LNURLPayParams{
+			Tag:             "payRequest",
+			Callback:        callback,
+			MinSendable:     1000,
+			MaxSendable:     100000000,
+			EncodedMetadata: `[["text/plain","test"]]`,
+		}
+		currencies.Advertise(&params)
+		return params
+	}
+	s := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			json.NewEncoder(w).Encode(params())
+			return
+		}
+		callbacks = append(callbacks, r.URL.Query())
+		msats, converted, err := currencies.Convert(r.URL.Query().Get("amount"), params())
+		if err != nil {
+			json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+			return
+		}
+		hash := sha256.Sum256([]byte(params().EncodedMetadata))
+		pr, _ := testInvoice(msats, hash[:])
+		json.NewEncoder(w).Encode(LNURLPayValues{LNURLResponse: OkResponse(), PR: pr, Converted: converted})
+	}))
+	callback = s.URL + "/callback"
+
+	_, lnurlParams, err := HandleLNURL(s.URL)
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	pay := lnurlParams.(LNURLPayParams)
+	usd, ok := pay.Currency("USD")
+	if !ok || len(pay.Currencies) != 2 || usd.Multiplier != 20000 ||
+		usd.Convertible.Min != 1 || usd.Convertible.Max != 5000 {
+		t.Fatalf("got currencies %+v", pay.Currencies)
+	}
+
+	values, err := pay.CallCurrency(250, "USD", 0, "", nil)
+	if err != nil || values.ParsedInvoice.MSatoshi != 5000000 {
+		t.Errorf("CallCurrency() got = %v, %v", values, err)
+	}
+	if sent := callbacks[0]; sent.Get("amount") != "250.USD" || sent.Has("convert") {
+		t.Errorf("CallCurrency() sent %v", sent)
+	}
+	if _, err := pay.CallCurrency(6000, "USD", 0, "", nil); err == nil {
+		t.Errorf("CallCurrency() accepted an amount out of bounds")
+	}
+	if _, err := pay.CallCurrency(250, "JPY", 0, "", nil); err == nil {
+		t.Errorf("CallCurrency() accepted a currency the service doesn't take")
+	}
+
+	currencies.Fee = 10000000
+	if _, err := pay.CallCurrency(250, "USD", 1000, "", nil); err == nil || !strings.Contains(err.Error(), "maximum is 1000") {
+		t.Errorf("CallCurrency() with a fee over the maximum error = %v", err)
+	}
+	currencies.Fee = 500
+	if values, err := pay.CallCurrency(250, "USD", 1000, "", nil); err != nil || values.ParsedInvoice.MSatoshi != 5000500 {
+		t.Errorf("CallCurrency() with a fee got = %v, %v", values, err)
+	}
+	currencies.Fee = 0
+
+	rates["USD"] = 20100
+	if _, err := pay.CallCurrency(250, "USD", 0, "", nil); err != nil {
+		t.Errorf("CallCurrency() after a small rate change error = %v", err)
+	}
+	rates["USD"] = 25000
+	if _, err := pay.CallCurrency(250, "USD", 0, "", nil); err == nil || !strings.Contains(err.Error(), "worth") {
+		t.Errorf("CallCurrency() after a big rate change error = %v", err)
+	}
+}
+
+func TestParseCurrencyAmount(t *testing.T) {
+	for s, want := range map[string]int64{"1000": 1000, "250.USD": 250} {
+		if amount, _, err := ParseCurrencyAmount(s); err != nil || amount != want {
+			t.Errorf("ParseCurrencyAmount(%s) got = %d, %v", s, amount, err)
+		}
+	}
+	for _, s := range []string{"1000.", "-5", ".USD", "x"} {
+		if _, _, err := ParseCurrencyAmount(s); err == nil {
+			t.Errorf("ParseCurrencyAmount(%s) succeeded", s)
+		}
+	}
+}
+
+func TestInvalidCurrencies(t *testing.T) {
+	raw := `{"tag": "payRequest", "callback": "https://service.com/cb", "metadata": "[[\"text/plain\", \"x\"]]",
+		"minSendable": 1000, "maxSendable": 2000, "currencies": [
+			{"code": "USD", "multiplier": 0, "decimals": 2, "convertible": {"min": 1, "max": 10}},
+			{"code": "EUR", "multiplier": 22000, "decimals": 2, "convertible": {"min": 1, "max": 10}}
+		]}`
+	params, err := HandlePay([]byte(raw))
+	if err != nil {
+		t.Fatalf("HandlePay() error = %v", err)
+	}
+	pay := params.(LNURLPayParams)
+	if len(pay.Currencies) != 1 || pay.Currencies[0].Code != "EUR" {
+		t.Errorf("HandlePay() kept currencies %+v", pay.Currencies)
+	}
+	if _, err := pay.CallCurrency(5, "USD", 0, "", nil); err == nil {
+		t.Errorf("CallCurrency() accepted an invalid currency")
+	}
+}
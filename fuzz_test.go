@@ -0,0 +1,138 @@
+package lnurl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func FuzzBech32(f *testing.F) {
+	f.Add("lnurl1dp68gurn8ghj7ctsdyhxv6tpw34xze3wvdhk6tmkxghkcmn4wfkz7urp0y0q3peg")
+	f.Add("LNURL1D3H82UNV9E3K7MG347503")
+	f.Add("a12uel5l")
+	f.Add("abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw")
+	f.Add("lnurl1D3H82UNV9E3K7MG347503")
+	f.Add("1qzzfhee")
+	f.Add("lnurl1")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		hrp, data, err := decode(s)
+		if err != nil {
+			return
+		}
+
+		encoded, err := encode(hrp, data)
+		if err != nil {
+			t.Fatalf("encode() error = %v after decoding '%s'", err, s)
+		}
+		if encoded != strings.ToLower(s) {
+			t.Fatalf("encode() got = %v, want %v", encoded, strings.ToLower(s))
+		}
+	})
+}
+
+func FuzzConvertBits(f *testing.F) {
+	f.Add([]byte("https://service.com/api?q=3fc3645b439ce8e7f2553a69e5267081d96dcd340693afabe04be7b0ccd178df"))
+	f.Add([]byte{})
+	f.Add([]byte{0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		converted, err := convertBits(data, 8, 5, true)
+		if err != nil {
+			t.Fatalf("convertBits(8, 5) error = %v", err)
+		}
+		back, err := convertBits(converted, 5, 8, false)
+		if err != nil {
+			t.Fatalf("convertBits(5, 8) error = %v", err)
+		}
+		if !bytes.Equal(back, data) {
+			t.Fatalf("convertBits() round trip got = %x, want %x", back, data)
+		}
+	})
+}
+
+func FuzzLNURLEncodeDecode(f *testing.F) {
+	f.Add("https://service.com/api?q=3fc3645b439ce8e7f2553a69e5267081d96dcd340693afabe04be7b0ccd178df")
+	f.Add("lnurlp://api.fiatjaf.onion/v2/lnurl/pay")
+	f.Add("http://测试假域名.onion/v1/lnurl/pay")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, actualurl string) {
+		encoded, err := LNURLEncode(actualurl)
+		if err != nil {
+			t.Skip()
+		}
+		if encoded != strings.ToUpper(encoded) {
+			t.Fatalf("LNURLEncode() got lowercase characters: %s", encoded)
+		}
+
+		decoded, err := LNURLDecode(encoded)
+		if err != nil {
+			t.Fatalf("LNURLDecode() error = %v for '%s'", err, encoded)
+		}
+		if decoded != actualurl {
+			t.Fatalf("LNURLDecode() got = %v, want %v", decoded, actualurl)
+		}
+	})
+}
+
+func FuzzLNURLDecode(f *testing.F) {
+	f.Add("lnurl1dp68gurn8ghj7ctsdyhxv6tpw34xze3wvdhk6tmkxghkcmn4wfkz7urp0y0q3peg")
+	f.Add("lnurlp://lnurl.fiatjaf.com")
+	f.Add("keyauth://domain.onion/login")
+	f.Add("https://lnurl.fiatjaf.com")
+	f.Add("lnurl1d3h82unvjhypn2")
+
+	f.Fuzz(func(t *testing.T, code string) {
+		LNURLDecode(code)
+		LNURLDecodeStrict(code)
+		LNURLEncodeStrict(code)
+		FindLNURLInText(code)
+		ExtractFallbackLNURL(code)
+	})
+}
+
+func FuzzNormalize(f *testing.F) {
+	f.Add(`[["text/plain","a"],["text/long-desc","b"],["image/png;base64","iVBORw0KGgo="],["text/identifier","a@b.c"]]`, "https://service.com/callback?x=1")
+	f.Add(`[["text/email","a@b.c"],["text/plain"],[1,2],"x",null]`, "")
+	f.Add(`[["image/jpeg;base64","%%%"]]`, "%zz")
+	f.Add(`{}`, "https://service.com")
+
+	f.Fuzz(func(t *testing.T, metadata string, callback string) {
+		params := LNURLPayParams{EncodedMetadata: metadata, Callback: callback}
+		if err := params.Normalize(); err != nil {
+			return
+		}
+		if encoded := params.Metadata.Encode(); encoded != metadata {
+			t.Fatalf("Encode() got = %s, want %s", encoded, metadata)
+		}
+	})
+}
+
+func FuzzAESDecipher(f *testing.F) {
+	key := bytes.Repeat([]byte{7}, 32)
+	ciphertext, iv, _ := AESCipher(key, []byte("hello"))
+	f.Add(key, ciphertext, iv)
+	f.Add(key, []byte{}, iv)
+	f.Add(key[:16], ciphertext[:15], iv)
+	f.Add([]byte{}, ciphertext, iv[:3])
+
+	f.Fuzz(func(t *testing.T, key, ciphertext, iv []byte) {
+		original := append([]byte(nil), ciphertext...)
+		plaintext, err := AESDecipher(key, ciphertext, iv)
+		if !bytes.Equal(ciphertext, original) {
+			t.Fatalf("AESDecipher() modified its input")
+		}
+		if err != nil {
+			return
+		}
+
+		recipher, iv2, err := AESCipher(key, plaintext)
+		if err != nil {
+			t.Fatalf("AESCipher() error = %v", err)
+		}
+		if back, err := AESDecipher(key, recipher, iv2); err != nil || !bytes.Equal(back, plaintext) {
+			t.Fatalf("AESDecipher() round trip got = %x, %v, want %x", back, err, plaintext)
+		}
+	})
+}
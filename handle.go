@@ -14,40 +14,9 @@ import (
 // Returns a different struct for each of the lnurl subprotocols, the .LNURLKind() method of
 // which should be checked next to see how the wallet is going to proceed.
 func HandleLNURL(rawlnurl string) (string, LNURLParams, error) {
-	var err error
-	var rawurl string
-
-	if name, domain, ok := ParseInternetIdentifier(rawlnurl); ok {
-		isOnion := strings.Index(domain, ".onion") == len(domain)-6
-		rawurl = domain + "/.well-known/lnurlp/" + name
-		if isOnion {
-			rawurl = "http://" + rawurl
-		} else {
-			rawurl = "https://" + rawurl
-		}
-	} else if strings.HasPrefix(rawlnurl, "http") {
-		rawurl = rawlnurl
-	} else if strings.HasPrefix(rawlnurl, "lnurlp://") ||
-		strings.HasPrefix(rawlnurl, "lnurlw://") ||
-		strings.HasPrefix(rawlnurl, "lnurla://") ||
-		strings.HasPrefix(rawlnurl, "keyauth://") {
-
-		scheme := "https:"
-		if strings.Contains(rawlnurl, ".onion/") || strings.HasSuffix(rawlnurl, ".onion") {
-			scheme = "http:"
-		}
-		location := strings.SplitN(rawlnurl, ":", 2)[1]
-		rawurl = scheme + location
-	} else {
-		lnurl, ok := FindLNURLInText(rawlnurl)
-		if !ok {
-			return "", nil,
-				errors.New("invalid bech32-encoded lnurl: " + rawlnurl)
-		}
-		rawurl, err = LNURLDecode(lnurl)
-		if err != nil {
-			return "", nil, err
-		}
+	rawurl, err := lnurlURL(rawlnurl)
+	if err != nil {
+		return "", nil, err
 	}
 
 	parsed, err := url.Parse(rawurl)
@@ -96,7 +65,55 @@ func HandleLNURL(rawlnurl string) (string, LNURLParams, error) {
 	case "channelRequest":
 		value, err := HandleChannel(b)
 		return rawurl, value, err
+	case "keysend":
+		value, err := HandleKeysend(b)
+		return rawurl, value, err
 	default:
 		return rawurl, nil, errors.New("unknown response tag " + j.String())
 	}
 }
+
+// lnurlURL returns the actual URL behind a bech32-encoded lnurl, a LUD-17 link, a LUD-01
+// fallback link or a lightning address.
+func lnurlURL(rawlnurl string) (string, error) {
+	var err error
+	var rawurl string
+
+	if name, domain, ok := ParseInternetIdentifier(rawlnurl); ok {
+		isOnion := strings.Index(domain, ".onion") == len(domain)-6
+		rawurl = domain + "/.well-known/lnurlp/" + name
+		if isOnion {
+			rawurl = "http://" + rawurl
+		} else {
+			rawurl = "https://" + rawurl
+		}
+	} else if strings.HasPrefix(rawlnurl, "http") {
+		// LUD-01 fallback links carry the actual lnurl in the `lightning` parameter
+		if lnurl, ok := ExtractFallbackLNURL(rawlnurl); ok {
+			return lnurlURL(lnurl)
+		}
+		rawurl = rawlnurl
+	} else if strings.HasPrefix(rawlnurl, "lnurlp://") ||
+		strings.HasPrefix(rawlnurl, "lnurlw://") ||
+		strings.HasPrefix(rawlnurl, "lnurla://") ||
+		strings.HasPrefix(rawlnurl, "keyauth://") {
+
+		scheme := "https:"
+		if strings.Contains(rawlnurl, ".onion/") || strings.HasSuffix(rawlnurl, ".onion") {
+			scheme = "http:"
+		}
+		location := strings.SplitN(rawlnurl, ":", 2)[1]
+		rawurl = scheme + location
+	} else {
+		lnurl, ok := FindLNURLInText(rawlnurl)
+		if !ok {
+			return "", errors.New("invalid bech32-encoded lnurl: " + rawlnurl)
+		}
+		rawurl, err = LNURLDecode(lnurl)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return rawurl, nil
+}
@@ -3,6 +3,7 @@ package lnurl
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"net/url"
 	"regexp"
 	"strings"
 )
@@ -52,3 +53,13 @@ func ParseInternetIdentifier(text string) (name, domain string, ok bool) {
 	ok = true
 	return
 }
+
+// cloneURL copies a URL so its query can be modified without touching the original.
+func cloneURL(u *url.URL) *url.URL {
+	c := *u
+	if u.User != nil {
+		user := *u.User
+		c.User = &user
+	}
+	return &c
+}
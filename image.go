@@ -0,0 +1,237 @@
+package lnurl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+)
+
+const (
+	// MaxImageSize is the maximum size in bytes of the decoded image in metadata. Wallets
+	// show small thumbnails and the whole metadata is sent with every payRequest, so
+	// anything bigger is just wasted bandwidth.
+	MaxImageSize = 100000
+
+	// MaxImageDimension is the maximum width and height of the image in metadata.
+	MaxImageDimension = 512
+
+	// MaxSourceImagePixels is the maximum width times height of the images given to
+	// SetImage, so images that declare huge dimensions are rejected before decoding.
+	MaxSourceImagePixels = 4096 * 4096
+)
+
+var (
+	pngMagic  = []byte("\x89PNG\r\n\x1a\n")
+	jpegMagic = []byte{0xff, 0xd8, 0xff}
+)
+
+// Validate checks the metadata against the rules of LUD-06 and LUD-16: entries must be
+// [mime, string] pairs, there must be exactly one text/plain entry and at most one
+// text/long-desc, image and identifier entries, and the image must be a PNG or JPEG of
+// at most MaxImageSize bytes and MaxImageDimension pixels wide and high. All problems
+// found are returned together.
+func (metadata Metadata) Validate() error {
+	var errs []error
+	counts := make(map[string]int)
+	for i, entry := range metadata.entries() {
+		if entry.Raw != nil {
+			var pair []interface{}
+			json.Unmarshal(entry.Raw, &pair)
+			if !isStringPair(pair) {
+				errs = append(errs, fmt.Errorf("entry %d is not a [mime, string] pair: %s", i, entry.Raw))
+				continue
+			}
+		}
+
+		switch entry.Type {
+		case "text/plain", "text/long-desc":
+			counts[entry.Type]++
+		case "text/identifier", "text/email":
+			counts["identifier"]++
+		case "image/png;base64", "image/jpeg;base64":
+			counts["image"]++
+			if err := validateImage(entry.Type, entry.Value); err != nil {
+				errs = append(errs, err)
+			}
+		default:
+			if strings.HasPrefix(entry.Type, "image/") {
+				errs = append(errs, fmt.Errorf("image type '%s' is not image/png;base64 or image/jpeg;base64", entry.Type))
+			}
+		}
+	}
+
+	if counts["text/plain"] != 1 {
+		errs = append(errs, fmt.Errorf("there must be exactly one text/plain entry, got %d", counts["text/plain"]))
+	}
+	if counts["text/long-desc"] > 1 {
+		errs = append(errs, errors.New("there must be at most one text/long-desc entry"))
+	}
+	if counts["image"] > 1 {
+		errs = append(errs, errors.New("there must be at most one image entry"))
+	}
+	if counts["identifier"] > 1 {
+		errs = append(errs, errors.New("there must be at most one text/identifier or text/email entry"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// SetImage replaces the image of the metadata with the given PNG, JPEG or GIF image,
+// re-encoded and downsized as needed to fit MaxImageDimension and MaxImageSize. Images of
+// more than MaxSourceImagePixels are rejected.
+func (metadata *Metadata) SetImage(data []byte) error {
+	typ, encoded, err := fitImage(data)
+	if err != nil {
+		return err
+	}
+
+	entries := metadata.entries()
+	metadata.Entries = make([]MetadataEntry, 0, len(entries)+1)
+	for _, entry := range entries {
+		if entry.Type != "image/png;base64" && entry.Type != "image/jpeg;base64" {
+			metadata.Entries = append(metadata.Entries, entry)
+		}
+	}
+	metadata.Add(typ, base64.StdEncoding.EncodeToString(encoded))
+	return nil
+}
+
+func isStringPair(pair []interface{}) bool {
+	if len(pair) != 2 {
+		return false
+	}
+	_, ok1 := pair[0].(string)
+	_, ok2 := pair[1].(string)
+	return ok1 && ok2
+}
+
+func validateImage(typ string, value string) error {
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return fmt.Errorf("%s entry is not valid base64: %w", typ, err)
+	}
+	if len(b) > MaxImageSize {
+		return fmt.Errorf("image is %d bytes, the maximum is %d", len(b), MaxImageSize)
+	}
+
+	magic := pngMagic
+	if typ == "image/jpeg;base64" {
+		magic = jpegMagic
+	}
+	if !bytes.HasPrefix(b, magic) {
+		return fmt.Errorf("%s entry doesn't contain a %s image", typ, typ[6:len(typ)-7])
+	}
+
+	config, err := imageConfig(b)
+	if err != nil {
+		return err
+	}
+	if config.Width > MaxImageDimension || config.Height > MaxImageDimension {
+		return fmt.Errorf("image is %dx%d, the maximum is %dx%d",
+			config.Width, config.Height, MaxImageDimension, MaxImageDimension)
+	}
+	return nil
+}
+
+// imageConfig reads the dimensions of an image without decoding it.
+func imageConfig(b []byte) (image.Config, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return image.Config{}, fmt.Errorf("invalid image: %w", err)
+	}
+	return config, nil
+}
+
+// fitImage returns the metadata type and the bytes of data re-encoded to fit the limits.
+// Images are kept as PNG if possible and turned into JPEG of decreasing quality, and then
+// of decreasing dimensions, otherwise.
+func fitImage(data []byte) (string, []byte, error) {
+	config, err := imageConfig(data)
+	if err != nil {
+		return "", nil, err
+	}
+	if pixels := int64(config.Width) * int64(config.Height); pixels > MaxSourceImagePixels {
+		return "", nil, fmt.Errorf("image is %dx%d, the maximum is %d pixels",
+			config.Width, config.Height, MaxSourceImagePixels)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid image: %w", err)
+	}
+
+	dimension := MaxImageDimension
+	for dimension >= 16 {
+		img = downsize(img, dimension)
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return "", nil, err
+		}
+		if buf.Len() <= MaxImageSize {
+			return "image/png;base64", buf.Bytes(), nil
+		}
+
+		for _, quality := range []int{90, 75, 60} {
+			buf.Reset()
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return "", nil, err
+			}
+			if buf.Len() <= MaxImageSize {
+				return "image/jpeg;base64", buf.Bytes(), nil
+			}
+		}
+
+		dimension /= 2
+	}
+
+	return "", nil, errors.New("image can't be made small enough")
+}
+
+// downsize scales img down, keeping the aspect ratio, so both its width and height are at
+// most dimension, averaging the pixels that get merged. Smaller images are returned as is.
+func downsize(img image.Image, dimension int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= dimension && h <= dimension {
+		return img
+	}
+
+	nw, nh := dimension, h*dimension/w
+	if h > w {
+		nw, nh = w*dimension/h, dimension
+	}
+	nw, nh = max(nw, 1), max(nh, 1)
+
+	out := image.NewNRGBA(image.Rect(0, 0, nw, nh))
+	for y := 0; y < nh; y++ {
+		y0, y1 := y*h/nh, max((y+1)*h/nh, y*h/nh+1)
+		for x := 0; x < nw; x++ {
+			x0, x1 := x*w/nw, max((x+1)*w/nw, x*w/nw+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(img.At(bounds.Min.X+sx, bounds.Min.Y+sy)).(color.NRGBA64)
+					r, g, b, a = r+uint64(c.R), g+uint64(c.G), b+uint64(c.B), a+uint64(c.A)
+					n++
+				}
+			}
+			out.SetNRGBA(x, y, color.NRGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return out
+}
@@ -0,0 +1,121 @@
+package lnurl
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// MinCustomRecordType is the lowest TLV type of the custom records of a keysend payment.
+const MinCustomRecordType = 65536
+
+// LNURLKeysendParams is what lightning addresses backed by a node return instead of a
+// payRequest: they are paid with keysend to PubKey, adding the CustomData records.
+type LNURLKeysendParams struct {
+	LNURLResponse
+	Tag        string              `json:"tag"`
+	PubKey     string              `json:"pubkey"`
+	CustomData []KeysendCustomData `json:"customData,omitempty"`
+
+	ParsedPubKey *btcec.PublicKey `json:"-"`
+}
+
+// KeysendCustomData is a custom TLV record to add to keysend payments, CustomKey being
+// the decimal type of the record.
+type KeysendCustomData struct {
+	CustomKey   string `json:"customKey"`
+	CustomValue string `json:"customValue"`
+}
+
+func (_ LNURLKeysendParams) LNURLKind() string { return "lnurl-keysend" }
+
+// NewKeysendParams returns the keysend params for payments to the node with pubkey, with
+// the given custom records.
+func NewKeysendParams(pubkey *btcec.PublicKey, records map[uint64]string) LNURLKeysendParams {
+	params := LNURLKeysendParams{
+		LNURLResponse: OkResponse(),
+		Tag:           "keysend",
+		PubKey:        hex.EncodeToString(pubkey.SerializeCompressed()),
+		ParsedPubKey:  pubkey,
+	}
+
+	types := make([]uint64, 0, len(records))
+	for typ := range records {
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, typ := range types {
+		params.CustomData = append(params.CustomData, KeysendCustomData{
+			CustomKey:   strconv.FormatUint(typ, 10),
+			CustomValue: records[typ],
+		})
+	}
+	return params
+}
+
+// Records returns the custom records to add to the payment, by TLV type.
+func (params LNURLKeysendParams) Records() (map[uint64][]byte, error) {
+	records := make(map[uint64][]byte, len(params.CustomData))
+	for _, data := range params.CustomData {
+		typ, err := strconv.ParseUint(data.CustomKey, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid customKey '%s'", data.CustomKey)
+		}
+		if typ < MinCustomRecordType {
+			return nil, fmt.Errorf("customKey %d is not in the custom records range", typ)
+		}
+		if _, ok := records[typ]; ok {
+			return nil, fmt.Errorf("customKey %d is repeated", typ)
+		}
+		records[typ] = []byte(data.CustomValue)
+	}
+	return records, nil
+}
+
+func HandleKeysend(raw []byte) (LNURLParams, error) {
+	var params LNURLKeysendParams
+	err := json.Unmarshal(raw, &params)
+	if err != nil {
+		return nil, err
+	}
+
+	bpubkey, err := hex.DecodeString(params.PubKey)
+	if err != nil || len(bpubkey) != 33 {
+		return nil, errors.New("pubkey is not a 33-byte hex-encoded key")
+	}
+	params.ParsedPubKey, err = btcec.ParsePubKey(bpubkey)
+	if err != nil {
+		return nil, errors.New("failed to parse pubkey: " + err.Error())
+	}
+
+	if _, err := params.Records(); err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+// KeysendHandler serves lightning addresses backed by a node. get returns the keysend
+// params for the requested address, usually made with NewKeysendParams, or an error that
+// is sent as the reason of an ERROR response.
+func KeysendHandler(get func(r *http.Request) (LNURLKeysendParams, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		params, err := get(r)
+		if err != nil {
+			json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+			return
+		}
+
+		params.Status = "OK"
+		params.Tag = "keysend"
+		json.NewEncoder(w).Encode(params)
+	})
+}
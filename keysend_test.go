@@ -0,0 +1,49 @@
+package lnurl
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+func TestKeysend(t *testing.T) {
+	key, _ := btcec.NewPrivateKey()
+	s := newTestServer(t, KeysendHandler(func(r *http.Request) (LNURLKeysendParams, error) {
+		switch strings.TrimPrefix(r.URL.Path, "/.well-known/lnurlp/") {
+		case "alice":
+			return NewKeysendParams(key.PubKey(), map[uint64]string{696969: "alice", 112111100: "wal_1"}), nil
+		case "bob":
+			params := NewKeysendParams(key.PubKey(), nil)
+			params.CustomData = []KeysendCustomData{{CustomKey: "34349334", CustomValue: "x"}, {CustomKey: "1", CustomValue: "y"}}
+			return params, nil
+		}
+		return LNURLKeysendParams{}, errors.New("unknown address")
+	}))
+	host := strings.TrimPrefix(s.URL, "https://")
+
+	_, params, err := HandleLNURL("alice@" + host)
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	keysend, ok := params.(LNURLKeysendParams)
+	if !ok || keysend.LNURLKind() != "lnurl-keysend" || !keysend.ParsedPubKey.IsEqual(key.PubKey()) {
+		t.Fatalf("HandleLNURL() got = %+v", params)
+	}
+	records, err := keysend.Records()
+	if err != nil || len(records) != 2 || string(records[696969]) != "alice" || string(records[112111100]) != "wal_1" {
+		t.Errorf("Records() got = %v, %v", records, err)
+	}
+
+	if _, _, err := HandleLNURL("bob@" + host); err == nil || !strings.Contains(err.Error(), "custom records range") {
+		t.Errorf("HandleLNURL() with a record out of range error = %v", err)
+	}
+	if _, _, err := HandleLNURL("carol@" + host); err == nil || err.Error() != "unknown address" {
+		t.Errorf("HandleLNURL() of an unknown address error = %v", err)
+	}
+	if _, err := HandleKeysend([]byte(`{"tag": "keysend", "pubkey": "02abcd"}`)); err == nil {
+		t.Errorf("HandleKeysend() accepted an invalid pubkey")
+	}
+}
@@ -0,0 +1,67 @@
+package lnurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+)
+
+// LinkingKeySigner signs with the linking keys of LUD-05, which are different for each
+// domain. It is an interface so the keys can stay in a signing device.
+type LinkingKeySigner interface {
+	// SignWithLinkingKey signs the 32-byte msg with the linking key for domain.
+	SignWithLinkingKey(domain string, msg []byte) (*ecdsa.Signature, *btcec.PublicKey, error)
+}
+
+// HDLinkingKeys derives linking keys from a BIP 32 master key as LUD-05 describes.
+type HDLinkingKeys struct {
+	Master *hdkeychain.ExtendedKey
+}
+
+// LinkingKey derives the linking key for domain: the hashing key m/138'/0 is used to
+// HMAC the domain, and the first 16 bytes of the result give the 4 indexes of the path
+// m/138'/a/b/c/d.
+func (keys HDLinkingKeys) LinkingKey(domain string) (*btcec.PrivateKey, error) {
+	if keys.Master == nil {
+		return nil, errors.New("no master key")
+	}
+
+	purpose, err := keys.Master.Derive(hdkeychain.HardenedKeyStart + 138)
+	if err != nil {
+		return nil, err
+	}
+	hashing, err := purpose.Derive(0)
+	if err != nil {
+		return nil, err
+	}
+	hashingKey, err := hashing.ECPrivKey()
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, hashingKey.Serialize())
+	mac.Write([]byte(domain))
+	material := mac.Sum(nil)
+
+	key := purpose
+	for i := 0; i < 4; i++ {
+		key, err = key.Derive(binary.BigEndian.Uint32(material[i*4:]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key.ECPrivKey()
+}
+
+func (keys HDLinkingKeys) SignWithLinkingKey(domain string, msg []byte) (*ecdsa.Signature, *btcec.PublicKey, error) {
+	key, err := keys.LinkingKey(domain)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ecdsa.Sign(key, msg), key.PubKey(), nil
+}
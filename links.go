@@ -0,0 +1,94 @@
+package lnurl
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// ValidateWithdrawLink checks that the withdrawLink of LUD-19 is an lnurl of the same
+// service as the callback.
+func (params LNURLPayParams) ValidateWithdrawLink() error {
+	return checkLinked(params.WithdrawLink, params.Callback)
+}
+
+// ResolveWithdrawLink validates and fetches the withdrawLink of LUD-19, so wallets can
+// offer to withdraw from a pay screen.
+func (params LNURLPayParams) ResolveWithdrawLink() (LNURLWithdrawResponse, error) {
+	if err := params.ValidateWithdrawLink(); err != nil {
+		return LNURLWithdrawResponse{}, err
+	}
+
+	linked, err := resolveLinked(params.WithdrawLink, params.Callback, "lnurl-withdraw")
+	if err != nil {
+		return LNURLWithdrawResponse{}, err
+	}
+	return linked.(LNURLWithdrawResponse), nil
+}
+
+// ValidatePayLink checks that the payLink of LUD-19 is an lnurl of the same service as
+// the callback.
+func (r LNURLWithdrawResponse) ValidatePayLink() error {
+	return checkLinked(r.PayLink, r.Callback)
+}
+
+// ResolvePayLink validates and fetches the payLink of LUD-19, so wallets can offer to top
+// up from a withdraw screen.
+func (r LNURLWithdrawResponse) ResolvePayLink() (LNURLPayParams, error) {
+	if err := r.ValidatePayLink(); err != nil {
+		return LNURLPayParams{}, err
+	}
+
+	linked, err := resolveLinked(r.PayLink, r.Callback, "lnurl-pay")
+	if err != nil {
+		return LNURLPayParams{}, err
+	}
+	return linked.(LNURLPayParams), nil
+}
+
+func checkLinked(link string, callback string) error {
+	if link == "" {
+		return fmt.Errorf("there is no link")
+	}
+
+	rawurl, err := lnurlURL(link)
+	if err != nil {
+		return fmt.Errorf("invalid link: %w", err)
+	}
+	linked, err := url.Parse(rawurl)
+	if err != nil || linked.Host == "" {
+		return fmt.Errorf("invalid link '%s'", link)
+	}
+	service, err := url.Parse(callback)
+	if err != nil {
+		return fmt.Errorf("callback is not a valid URL")
+	}
+
+	if linked.Host != service.Host {
+		return fmt.Errorf("link is for '%s', not for the same service as the callback '%s'",
+			linked.Host, service.Host)
+	}
+	return nil
+}
+
+func resolveLinked(link string, callback string, kind string) (LNURLParams, error) {
+	_, params, err := HandleLNURL(link)
+	if err != nil {
+		return nil, err
+	}
+	if params.LNURLKind() != kind {
+		return nil, fmt.Errorf("link is an %s, not an %s", params.LNURLKind(), kind)
+	}
+
+	// the linked params' own callback must be on the same service too
+	switch linked := params.(type) {
+	case LNURLPayParams:
+		err = checkLinked(linked.Callback, callback)
+	case LNURLWithdrawResponse:
+		err = checkLinked(linked.Callback, callback)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("linked service callback: %w", err)
+	}
+
+	return params, nil
+}
@@ -0,0 +1,63 @@
+package lnurl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLinkedLNURLs(t *testing.T) {
+	var s *httptest.Server
+	s = newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.TrimPrefix(s.URL, "https://")
+		switch r.URL.Path {
+		case "/pay":
+			json.NewEncoder(w).Encode(LNURLPayParams{
+				Tag:             "payRequest",
+				Callback:        s.URL + "/pay/callback",
+				MinSendable:     1000,
+				MaxSendable:     1000000,
+				EncodedMetadata: `[["text/plain","top up"]]`,
+				WithdrawLink:    "lnurlw://" + host + "/withdraw",
+			})
+		case "/withdraw":
+			json.NewEncoder(w).Encode(LNURLWithdrawResponse{
+				Tag:             "withdrawRequest",
+				K1:              RandomK1(),
+				Callback:        s.URL + "/withdraw/callback",
+				MinWithdrawable: 1000,
+				MaxWithdrawable: 1000000,
+				PayLink:         "lnurlp://" + host + "/pay",
+			})
+		}
+	}))
+
+	_, params, err := HandleLNURL("lnurlw://" + strings.TrimPrefix(s.URL, "https://") + "/withdraw")
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	withdraw := params.(LNURLWithdrawResponse)
+
+	pay, err := withdraw.ResolvePayLink()
+	if err != nil {
+		t.Fatalf("ResolvePayLink() error = %v", err)
+	}
+	if pay.Metadata.Description != "top up" {
+		t.Errorf("ResolvePayLink() got = %+v", pay)
+	}
+	if back, err := pay.ResolveWithdrawLink(); err != nil || back.PayLink != withdraw.PayLink {
+		t.Errorf("ResolveWithdrawLink() got = %+v, %v", back, err)
+	}
+
+	withdraw.PayLink = "lnurlp://other.com/pay"
+	if err := withdraw.ValidatePayLink(); err == nil {
+		t.Errorf("ValidatePayLink() accepted a link to another service")
+	}
+	pay.WithdrawLink = strings.Replace(pay.WithdrawLink, "lnurlw://", "lnurlp://", 1)
+	pay.WithdrawLink = strings.Replace(pay.WithdrawLink, "/withdraw", "/pay", 1)
+	if _, err := pay.ResolveWithdrawLink(); err == nil || !strings.Contains(err.Error(), "not an lnurl-withdraw") {
+		t.Errorf("ResolveWithdrawLink() of a pay link error = %v", err)
+	}
+}
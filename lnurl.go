@@ -17,8 +17,12 @@ var actualClient = &http.Client{
 
 type onioncapabletransport struct{}
 
-func WithCustomClient(c *http.Client) {
+// WithCustomClient sets the client used for all requests. It returns the client it
+// replaces, so tests can restore it; calls that ignore the result work as before.
+func WithCustomClient(c *http.Client) *http.Client {
+	previous := actualClient
 	actualClient = c
+	return previous
 }
 
 func (_ onioncapabletransport) RoundTrip(r *http.Request) (*http.Response, error) {
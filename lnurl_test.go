@@ -0,0 +1,54 @@
+package lnurl
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+// newTestServer starts a TLS server with handler and makes the client trust it until the
+// test ends.
+func newTestServer(t *testing.T, handler http.Handler) *httptest.Server {
+	s := httptest.NewTLSServer(handler)
+	previous := WithCustomClient(s.Client())
+	t.Cleanup(func() {
+		WithCustomClient(previous)
+		s.Close()
+	})
+	return s
+}
+
+var testNodeKey, _ = btcec.NewPrivateKey()
+
+// testInvoice returns a mainnet invoice for msats committing to descriptionHash, and its
+// preimage.
+func testInvoice(msats int64, descriptionHash []byte) (pr string, preimage []byte) {
+	preimage = make([]byte, 32)
+	rand.Read(preimage)
+	var h, secret [32]byte
+	copy(h[:], descriptionHash)
+	rand.Read(secret[:])
+
+	inv, err := zpay32.NewInvoice(&chaincfg.MainNetParams, sha256.Sum256(preimage), time.Now(),
+		zpay32.Amount(lnwire.MilliSatoshi(msats)), zpay32.DescriptionHash(h), zpay32.PaymentAddr(secret))
+	if err != nil {
+		panic(err)
+	}
+	pr, err = inv.Encode(zpay32.MessageSigner{SignCompact: func(msg []byte) ([]byte, error) {
+		return ecdsa.SignCompact(testNodeKey, chainhash.HashB(msg), true)
+	}})
+	if err != nil {
+		panic(err)
+	}
+	return pr, preimage
+}
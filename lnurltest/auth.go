@@ -0,0 +1,71 @@
+package lnurltest
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/fiatjaf/go-lnurl"
+)
+
+// AuthServer is a fake lnurl-auth service. Every call to LNURL issues a new challenge,
+// and signatures for it are verified on /login.
+type AuthServer struct {
+	Server
+
+	mu     sync.Mutex
+	issued map[string]bool
+	logins []string
+}
+
+// NewAuthServer starts a fake lnurl-auth service.
+func NewAuthServer() *AuthServer {
+	s := &AuthServer{issued: make(map[string]bool)}
+	s.start(s.handle)
+	return s
+}
+
+// LNURL returns the bech32-encoded lnurl for a new login challenge.
+func (s *AuthServer) LNURL() string {
+	k1 := lnurl.RandomK1()
+
+	s.mu.Lock()
+	s.issued[k1] = true
+	s.mu.Unlock()
+
+	return s.LNURLAt("/login?tag=login&action=login&k1=" + k1)
+}
+
+// Logins returns the hex-encoded linking keys that logged in so far, in order.
+func (s *AuthServer) Logins() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.logins...)
+}
+
+func (s *AuthServer) handle(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	k1 := query.Get("k1")
+
+	s.mu.Lock()
+	issued := s.issued[k1]
+	s.mu.Unlock()
+	if !issued {
+		respond(w, lnurl.ErrorResponse("unknown k1"))
+		return
+	}
+
+	if ok, err := lnurl.VerifySignature(k1, query.Get("sig"), query.Get("key")); err != nil {
+		respond(w, lnurl.ErrorResponse(err.Error()))
+		return
+	} else if !ok {
+		respond(w, lnurl.ErrorResponse("invalid signature"))
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.issued, k1)
+	s.logins = append(s.logins, query.Get("key"))
+	s.mu.Unlock()
+
+	respond(w, lnurl.OkResponse())
+}
@@ -0,0 +1,96 @@
+package lnurltest
+
+import (
+	"encoding/hex"
+	"net/http"
+	"sync"
+
+	"github.com/fiatjaf/go-lnurl"
+)
+
+// ChannelServer is a fake lnurl-channel service. Its parameters are served on every path
+// except /callback, which records channel requests.
+type ChannelServer struct {
+	Server
+
+	// Params is served as the channelRequest, k1, uri and the callback are filled in
+	// automatically if empty.
+	Params lnurl.LNURLChannelResponse
+
+	// CallbackFail makes only the callback respond with an ERROR with this reason.
+	CallbackFail string
+
+	mu       sync.Mutex
+	channels []ChannelRequest
+}
+
+// ChannelRequest is what a wallet sent to the callback of a ChannelServer.
+type ChannelRequest struct {
+	RemoteID string
+	Private  bool
+}
+
+// NewChannelServer starts a fake lnurl-channel service.
+func NewChannelServer() *ChannelServer {
+	s := &ChannelServer{
+		Params: lnurl.LNURLChannelResponse{
+			Tag: "channelRequest",
+			K1:  lnurl.RandomK1(),
+			URI: hex.EncodeToString(NodeKey.PubKey().SerializeCompressed()) + "@127.0.0.1:9735",
+		},
+	}
+	s.start(s.handle)
+	return s
+}
+
+// LNURL returns the bech32-encoded lnurl of the service.
+func (s *ChannelServer) LNURL() string {
+	return s.LNURLAt("/")
+}
+
+// Channels returns the channel requests received so far, in order.
+func (s *ChannelServer) Channels() []ChannelRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ChannelRequest(nil), s.channels...)
+}
+
+func (s *ChannelServer) handle(w http.ResponseWriter, r *http.Request) {
+	params := s.Params
+	if params.Callback == "" {
+		params.Callback = s.URL + "/callback"
+	}
+
+	if r.URL.Path != "/callback" {
+		respond(w, params)
+		return
+	}
+
+	if s.CallbackFail != "" {
+		respond(w, lnurl.ErrorResponse(s.CallbackFail))
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("k1") != params.K1 {
+		respond(w, lnurl.ErrorResponse("unknown k1"))
+		return
+	}
+	if query.Get("cancel") == "1" {
+		respond(w, lnurl.OkResponse())
+		return
+	}
+	if b, err := hex.DecodeString(query.Get("remoteid")); err != nil || len(b) != 33 {
+		respond(w, lnurl.ErrorResponse("invalid remoteid"))
+		return
+	}
+
+	s.mu.Lock()
+	s.channels = append(s.channels, ChannelRequest{
+		RemoteID: query.Get("remoteid"),
+		Private:  query.Get("private") == "1",
+	})
+	s.mu.Unlock()
+
+	respond(w, lnurl.OkResponse())
+}
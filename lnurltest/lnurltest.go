@@ -0,0 +1,144 @@
+// Package lnurltest provides fake lnurl services backed by httptest servers, for testing
+// code that calls HandleLNURL and the Call methods of the lnurl package.
+//
+// All servers use TLS, as wallets expect https callbacks, so the client must trust them:
+//
+//	s := lnurltest.NewPayServer()
+//	defer s.Close()
+//	previous := lnurl.WithCustomClient(s.Client())
+//	defer lnurl.WithCustomClient(previous)
+//	_, params, err := lnurl.HandleLNURL(s.LNURL())
+//
+// As the certificate used by httptest is the same for all servers, the client of any of
+// them can be used.
+//
+// Every server records the requests it receives and has knobs to make it misbehave.
+package lnurltest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/fiatjaf/go-lnurl"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
+)
+
+// NodeKey is the key of the fake lightning node that signs all invoices.
+var NodeKey, _ = btcec.NewPrivateKey()
+
+// NewInvoice returns a mainnet bolt11 invoice signed by NodeKey and the preimage of its
+// payment hash. A nil descriptionHash makes an invoice with a plain "test" description.
+func NewInvoice(msats int64, descriptionHash []byte) (pr string, preimage []byte) {
+	preimage = make([]byte, 32)
+	rand.Read(preimage)
+
+	options := []func(*zpay32.Invoice){zpay32.Amount(lnwire.MilliSatoshi(msats))}
+	if descriptionHash != nil {
+		var h [32]byte
+		copy(h[:], descriptionHash)
+		options = append(options, zpay32.DescriptionHash(h))
+	} else {
+		options = append(options, zpay32.Description("test"))
+	}
+
+	var secret [32]byte
+	rand.Read(secret[:])
+	options = append(options, zpay32.PaymentAddr(secret))
+
+	inv, err := zpay32.NewInvoice(&chaincfg.MainNetParams, sha256.Sum256(preimage), time.Now(), options...)
+	if err != nil {
+		panic(err)
+	}
+	pr, err = inv.Encode(zpay32.MessageSigner{SignCompact: func(msg []byte) ([]byte, error) {
+		return ecdsa.SignCompact(NodeKey, chainhash.HashB(msg), true)
+	}})
+	if err != nil {
+		panic(err)
+	}
+	return pr, preimage
+}
+
+// Request is a request received by a fake server.
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Time   time.Time
+}
+
+// Server holds what is common to all fake services.
+type Server struct {
+	*httptest.Server
+
+	// Delay is waited before every response, to simulate slow services.
+	Delay time.Duration
+
+	// Fail makes every response an ERROR with this reason.
+	Fail string
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+func (s *Server) start(handler http.HandlerFunc) {
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.Query(),
+			Header: r.Header.Clone(),
+			Time:   time.Now(),
+		})
+		delay, fail := s.Delay, s.Fail
+		s.mu.Unlock()
+
+		time.Sleep(delay)
+		if fail != "" {
+			respond(w, lnurl.ErrorResponse(fail))
+			return
+		}
+		handler(w, r)
+	}))
+}
+
+// Requests returns all the requests received so far, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestsTo returns the requests received so far for a given path.
+func (s *Server) RequestsTo(path string) []Request {
+	var result []Request
+	for _, r := range s.Requests() {
+		if r.Path == path {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// LNURLAt returns the bech32-encoded lnurl for a path in the server.
+func (s *Server) LNURLAt(path string) string {
+	encoded, _ := lnurl.LNURLEncode(s.URL + path)
+	return encoded
+}
+
+func respond(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
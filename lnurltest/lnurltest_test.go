@@ -0,0 +1,286 @@
+package lnurltest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/fiatjaf/go-lnurl"
+)
+
+// trust makes the lnurl client trust s until the test ends, then closes s.
+func trust(t *testing.T, s interface {
+	Client() *http.Client
+	Close()
+}) {
+	previous := lnurl.WithCustomClient(s.Client())
+	t.Cleanup(func() {
+		lnurl.WithCustomClient(previous)
+		s.Close()
+	})
+}
+
+func TestPayServer(t *testing.T) {
+	s := NewLightningAddressServer("alice")
+	trust(t, s)
+
+	for _, target := range []string{s.LNURL(), s.LightningAddress()} {
+		_, params, err := lnurl.HandleLNURL(target)
+		if err != nil {
+			t.Fatalf("HandleLNURL(%s) error = %v", target, err)
+		}
+		pay := params.(lnurl.LNURLPayParams)
+		if pay.Metadata.LightningAddress != s.LightningAddress() {
+			t.Errorf("got metadata %v", pay.Metadata)
+		}
+
+		values, err := pay.Call(21000, "hello", nil)
+		if err != nil {
+			t.Fatalf("Call() error = %v", err)
+		}
+		if values.ParsedInvoice.MSatoshi != 21000 {
+			t.Errorf("Call() got invoice for %d", values.ParsedInvoice.MSatoshi)
+		}
+	}
+
+	invoices := s.Invoices()
+	if len(invoices) != 2 || invoices[1].Comment != "hello" || invoices[1].MSats != 21000 {
+		t.Errorf("Invoices() got = %v", invoices)
+	}
+	if n := len(s.RequestsTo("/callback")); n != 2 {
+		t.Errorf("RequestsTo() got %d callback requests", n)
+	}
+}
+
+func TestPayServerMisbehavior(t *testing.T) {
+	s := NewPayServer()
+	trust(t, s)
+
+	_, params, err := lnurl.HandleLNURL(s.LNURL())
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	pay := params.(lnurl.LNURLPayParams)
+
+	if _, err := pay.Call(5000, strings.Repeat("ü", 141), nil); err == nil {
+		t.Errorf("Call() accepted a comment over commentAllowed")
+	}
+	if values, err := pay.Call(5000, strings.Repeat("ü", 140), nil); err != nil || values.Reusable() {
+		t.Errorf("Call() with the longest comment got = %v, %v", values, err)
+	}
+	pay.CommentAllowed = 0
+	if _, err := pay.Call(5000, "hi", nil); err == nil {
+		t.Errorf("Call() accepted a comment when they aren't allowed")
+	}
+
+	s.Disposable = lnurl.FALSE
+	if values, err := pay.Call(5000, "", nil); err != nil || !values.Reusable() {
+		t.Errorf("Call() with disposable false got = %v, %v", values, err)
+	}
+	s.Disposable = nil
+
+	s.WrongAmount = 1000
+	if _, err := pay.Call(5000, "", nil); err == nil || !strings.Contains(err.Error(), "wrong amount") {
+		t.Errorf("Call() with wrong amount error = %v", err)
+	}
+	s.WrongAmount = 0
+
+	s.CallbackFail = "no invoices today"
+	if _, err := pay.Call(5000, "", nil); err == nil || err.Error() != "no invoices today" {
+		t.Errorf("Call() with failing callback error = %v", err)
+	}
+	s.CallbackFail = ""
+
+	s.Fail = "service down"
+	if _, _, err := lnurl.HandleLNURL(s.LNURL()); err == nil || err.Error() != "service down" {
+		t.Errorf("HandleLNURL() with failing service error = %v", err)
+	}
+	s.Fail = ""
+
+	s.Delay = 50 * time.Millisecond
+	start := time.Now()
+	if _, _, err := lnurl.HandleLNURL(s.LNURL()); err != nil || time.Since(start) < s.Delay {
+		t.Errorf("HandleLNURL() with slow service error = %v after %s", err, time.Since(start))
+	}
+}
+
+func TestPayServerVerify(t *testing.T) {
+	s := NewPayServer()
+	trust(t, s)
+
+	_, params, err := lnurl.HandleLNURL(s.LNURL())
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	values, err := params.(lnurl.LNURLPayParams).Call(5000, "", nil)
+	if err != nil {
+		t.Fatalf("Call() error = %v", err)
+	}
+
+	if status, err := values.Verify(); err != nil || status.Settled {
+		t.Errorf("Verify() before Settle() got = %+v, %v", status, err)
+	}
+	if !s.Settle(values.PR) {
+		t.Fatalf("Settle() didn't find the invoice")
+	}
+	status, err := values.Verify()
+	if err != nil || !status.Settled || status.Preimage != hex.EncodeToString(s.Invoices()[0].Preimage) {
+		t.Errorf("Verify() after Settle() got = %+v, %v", status, err)
+	}
+}
+
+func TestPayServerZaps(t *testing.T) {
+	s := NewLightningAddressServer("alice")
+	trust(t, s)
+	serviceKey, _ := btcec.NewPrivateKey()
+	relay := &Relay{}
+	s.Zaps = &lnurl.ZapServer{Key: serviceKey, Publisher: relay}
+
+	_, params, err := lnurl.HandleLNURL(s.LightningAddress())
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	pay := params.(lnurl.LNURLPayParams)
+	if !pay.AllowsNostr || len(pay.NostrPubkey) != 64 {
+		t.Fatalf("HandleLNURL() got allowsNostr %v and nostrPubkey %s", pay.AllowsNostr, pay.NostrPubkey)
+	}
+
+	senderKey, _ := btcec.NewPrivateKey()
+	recipient := strings.Repeat("ab", 32)
+	zap := lnurl.NewZapRequest(recipient, 21000, s.LNURL(), []string{"wss://relay.example.com"}, "great post")
+	zap.Tags = append(zap.Tags, []string{"e", strings.Repeat("cd", 32)})
+	zap.Sign(senderKey)
+
+	values, err := pay.CallZap(21000, zap)
+	if err != nil {
+		t.Fatalf("CallZap() error = %v", err)
+	}
+	s.Settle(values.PR)
+
+	receipts := relay.Receipts()
+	if len(receipts) != 1 || receipts[0].Relays[0] != "wss://relay.example.com" {
+		t.Fatalf("Receipts() got = %v", receipts)
+	}
+	receipt := receipts[0].Receipt
+	if err := receipt.CheckSignature(); err != nil || receipt.Kind != lnurl.KindZapReceipt || receipt.PubKey != pay.NostrPubkey {
+		t.Errorf("zap receipt got = %+v, %v", receipt, err)
+	}
+	if receipt.Tag("p")[1] != recipient || receipt.Tag("e") == nil || receipt.Tag("P")[1] != zap.PubKey ||
+		receipt.Tag("bolt11")[1] != values.PR || receipt.Tag("preimage") == nil {
+		t.Errorf("zap receipt got tags %v", receipt.Tags)
+	}
+	var described lnurl.NostrEvent
+	if err := json.Unmarshal([]byte(receipt.Tag("description")[1]), &described); err != nil || described.ID != zap.ID {
+		t.Errorf("zap receipt description got = %v, %v", described, err)
+	}
+
+	s.Zaps = nil
+	if _, err := pay.CallZap(21000, zap); err == nil || !strings.Contains(err.Error(), "zap request") {
+		t.Errorf("CallZap() to a server ignoring the zap error = %v", err)
+	}
+}
+
+func TestWithdrawServer(t *testing.T) {
+	s := NewWithdrawServer()
+	trust(t, s)
+
+	_, params, err := lnurl.HandleLNURL(s.LNURL())
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	withdraw := params.(lnurl.LNURLWithdrawResponse)
+
+	pr, _ := NewInvoice(withdraw.MaxWithdrawable, nil)
+	if err := withdraw.Call(pr); err != nil {
+		t.Fatalf("Call() error = %v", err)
+	}
+	tooMuch, _ := NewInvoice(withdraw.MaxWithdrawable+1000, nil)
+	if err := withdraw.Call(tooMuch); err == nil {
+		t.Errorf("Call() accepted an invoice above maxWithdrawable")
+	}
+
+	if invoices := s.Invoices(); len(invoices) != 1 || invoices[0] != pr {
+		t.Errorf("Invoices() got = %v", invoices)
+	}
+}
+
+func TestWithdrawServerBalanceCheck(t *testing.T) {
+	s := NewWithdrawServer()
+	trust(t, s)
+	s.Reusable = true
+
+	_, params, err := lnurl.HandleLNURL(s.LNURL())
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	withdraw := params.(lnurl.LNURLWithdrawResponse)
+
+	pr, _ := NewInvoice(30000000, nil)
+	if err := withdraw.Call(pr); err != nil {
+		t.Fatalf("Call() error = %v", err)
+	}
+
+	fresh, err := withdraw.CheckBalance()
+	if err != nil {
+		t.Fatalf("CheckBalance() error = %v", err)
+	}
+	if fresh.MaxWithdrawable != withdraw.MaxWithdrawable-30000000 || fresh.BalanceCheck != withdraw.BalanceCheck {
+		t.Errorf("CheckBalance() got = %+v", fresh)
+	}
+
+	wallet := NewChannelServer()
+	defer wallet.Close()
+	pr, _ = NewInvoice(1000000, nil)
+	if err := withdraw.CallNotify(pr, wallet.URL+"/notify"); err != nil {
+		t.Fatalf("CallNotify() error = %v", err)
+	}
+	if err := s.AddBalance(5000000); err != nil {
+		t.Fatalf("AddBalance() error = %v", err)
+	}
+	if notified := wallet.RequestsTo("/notify"); len(notified) != 1 || notified[0].Method != "POST" {
+		t.Errorf("AddBalance() notified with %v", notified)
+	}
+	if fresh, _ := withdraw.CheckBalance(); fresh.MaxWithdrawable != withdraw.MaxWithdrawable-26000000 {
+		t.Errorf("CheckBalance() after AddBalance() got = %d", fresh.MaxWithdrawable)
+	}
+}
+
+func TestAuthServer(t *testing.T) {
+	s := NewAuthServer()
+	trust(t, s)
+
+	_, params, err := lnurl.HandleLNURL(s.LNURL())
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	auth := params.(lnurl.LNURLAuthParams)
+
+	key, _ := btcec.NewPrivateKey()
+	if err := auth.Call(key); err != nil {
+		t.Fatalf("Call() error = %v", err)
+	}
+	if err := auth.Call(key); err == nil {
+		t.Errorf("Call() reused a k1")
+	}
+
+	if logins := s.Logins(); len(logins) != 1 {
+		t.Errorf("Logins() got = %v", logins)
+	}
+}
+
+func TestChannelServer(t *testing.T) {
+	s := NewChannelServer()
+	trust(t, s)
+
+	_, params, err := lnurl.HandleLNURL(s.LNURL())
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	if channel := params.(lnurl.LNURLChannelResponse); channel.URI != s.Params.URI {
+		t.Errorf("HandleLNURL() got uri %s", channel.URI)
+	}
+}
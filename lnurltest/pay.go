@@ -0,0 +1,246 @@
+package lnurltest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fiatjaf/go-lnurl"
+)
+
+// PayServer is a fake lnurl-pay service. Its parameters are served on every path except
+// /callback, which issues invoices, and /verify/, where the status of each invoice is
+// served as LUD-21 describes.
+type PayServer struct {
+	Server
+
+	// Params is served as the payRequest, the callback is filled in automatically.
+	Params lnurl.LNURLPayParams
+
+	// SuccessAction and Disposable are returned along with every invoice.
+	SuccessAction *lnurl.SuccessAction
+	Disposable    *bool
+
+	// CallbackFail makes only the callback respond with an ERROR with this reason.
+	CallbackFail string
+
+	// WrongAmount is added to the amount of every invoice issued.
+	WrongAmount int64
+
+	// WrongDescriptionHash makes invoices commit to the hash of something else than the
+	// metadata.
+	WrongDescriptionHash bool
+
+	// Zaps, if set, makes the service accept zaps, publishing receipts when invoices are
+	// settled with Settle.
+	Zaps *lnurl.ZapServer
+
+	name     string
+	mu       sync.Mutex
+	invoices []IssuedInvoice
+}
+
+// IssuedInvoice is an invoice returned by the callback of a PayServer, along with what the
+// wallet sent to get it.
+type IssuedInvoice struct {
+	PR        string
+	Preimage  []byte
+	MSats     int64
+	Comment   string
+	PayerData *lnurl.PayerDataValues
+	Settled   bool
+}
+
+// NewPayServer starts a fake lnurl-pay service accepting from 1 to 100000 satoshis.
+func NewPayServer() *PayServer {
+	s := &PayServer{
+		Params: lnurl.LNURLPayParams{
+			Tag:            "payRequest",
+			MinSendable:    1000,
+			MaxSendable:    100000000,
+			CommentAllowed: 140,
+			Metadata:       lnurl.Metadata{Description: "test payment"},
+		},
+	}
+	s.start(s.handle)
+	return s
+}
+
+// NewLightningAddressServer starts a fake lnurl-pay service for the lightning address
+// name@host, where host is the address of the server.
+func NewLightningAddressServer(name string) *PayServer {
+	s := NewPayServer()
+	s.name = name
+	s.Params.Metadata.Description = "payment to " + s.LightningAddress()
+	s.Params.Metadata.LightningAddress = s.LightningAddress()
+	return s
+}
+
+// LNURL returns the bech32-encoded lnurl of the service.
+func (s *PayServer) LNURL() string {
+	return s.LNURLAt("/")
+}
+
+// LightningAddress returns the address served by a server created with
+// NewLightningAddressServer.
+func (s *PayServer) LightningAddress() string {
+	return s.name + "@" + strings.TrimPrefix(s.URL, "https://")
+}
+
+// Invoices returns all the invoices issued so far, in order.
+func (s *PayServer) Invoices() []IssuedInvoice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]IssuedInvoice(nil), s.invoices...)
+}
+
+// Settle marks an issued invoice as paid, returning false if it wasn't issued. The zap
+// receipt is published if the invoice was for a zap.
+func (s *PayServer) Settle(pr string) bool {
+	s.mu.Lock()
+	var preimage []byte
+	for i := range s.invoices {
+		if s.invoices[i].PR == pr {
+			s.invoices[i].Settled = true
+			preimage = s.invoices[i].Preimage
+		}
+	}
+	s.mu.Unlock()
+
+	if preimage == nil {
+		return false
+	}
+	if s.Zaps != nil {
+		s.Zaps.Paid(pr, preimage)
+	}
+	return true
+}
+
+// LookupInvoice implements lnurl.InvoiceLookup for the invoices issued so far.
+func (s *PayServer) LookupInvoice(paymentHash string) (*lnurl.InvoiceStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, invoice := range s.invoices {
+		hash := sha256.Sum256(invoice.Preimage)
+		if hex.EncodeToString(hash[:]) == paymentHash {
+			return &lnurl.InvoiceStatus{
+				PR:       invoice.PR,
+				Settled:  invoice.Settled,
+				Preimage: invoice.Preimage,
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *PayServer) handle(w http.ResponseWriter, r *http.Request) {
+	params := s.Params
+	params.Callback = s.URL + "/callback"
+	params.EncodedMetadata = params.MetadataEncoded()
+	if s.Zaps != nil {
+		s.Zaps.Enable(&params)
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/verify/") {
+		lnurl.VerifyHandler(s).ServeHTTP(w, r)
+		return
+	}
+
+	if r.URL.Path != "/callback" {
+		respond(w, params)
+		return
+	}
+
+	if s.CallbackFail != "" {
+		respond(w, lnurl.ErrorResponse(s.CallbackFail))
+		return
+	}
+
+	query := r.URL.Query()
+	msats, err := strconv.ParseInt(query.Get("amount"), 10, 64)
+	if err != nil {
+		respond(w, lnurl.ErrorResponse("invalid amount"))
+		return
+	}
+	if msats < params.MinSendable || msats > params.MaxSendable {
+		respond(w, lnurl.ErrorResponse("amount out of bounds"))
+		return
+	}
+
+	comment := query.Get("comment")
+	if int64(len([]rune(comment))) > params.CommentAllowed {
+		respond(w, lnurl.ErrorResponse("comment too long"))
+		return
+	}
+
+	var payerdata *lnurl.PayerDataValues
+	if raw := query.Get("payerdata"); raw != "" {
+		payerdata = &lnurl.PayerDataValues{}
+		if err := json.Unmarshal([]byte(raw), payerdata); err != nil {
+			respond(w, lnurl.ErrorResponse("invalid payerdata"))
+			return
+		}
+	}
+
+	if params.PayerData != nil && params.PayerData.KeyAuth != nil {
+		if payerdata == nil || payerdata.KeyAuth == nil {
+			if params.PayerData.KeyAuth.Mandatory {
+				respond(w, lnurl.ErrorResponse("auth is mandatory"))
+				return
+			}
+		} else if err := payerdata.KeyAuth.Verify(params.PayerData.KeyAuth.K1); err != nil {
+			respond(w, lnurl.ErrorResponse("invalid auth: "+err.Error()))
+			return
+		}
+	}
+
+	described := params.EncodedMetadata
+	if payerdata != nil {
+		// LUD-18: the description hash commits to the payerdata too
+		described += query.Get("payerdata")
+	}
+	if s.WrongDescriptionHash {
+		described += "wrong"
+	}
+	hash := sha256.Sum256([]byte(described))
+	descriptionHash := hash[:]
+
+	nostr := query.Get("nostr")
+	if nostr != "" && s.Zaps != nil {
+		// NIP-57: the invoice commits to the zap request instead
+		descriptionHash, err = s.Zaps.DescriptionHash(nostr, msats)
+		if err != nil {
+			respond(w, lnurl.ErrorResponse(err.Error()))
+			return
+		}
+	}
+
+	pr, preimage := NewInvoice(msats+s.WrongAmount, descriptionHash)
+	if nostr != "" && s.Zaps != nil {
+		s.Zaps.Issued(nostr, pr)
+	}
+
+	s.mu.Lock()
+	s.invoices = append(s.invoices, IssuedInvoice{
+		PR:        pr,
+		Preimage:  preimage,
+		MSats:     msats,
+		Comment:   comment,
+		PayerData: payerdata,
+	})
+	s.mu.Unlock()
+
+	paymentHash := sha256.Sum256(preimage)
+	respond(w, lnurl.LNURLPayValues{
+		LNURLResponse: lnurl.OkResponse(),
+		PR:            pr,
+		Routes:        []interface{}{},
+		SuccessAction: s.SuccessAction,
+		Disposable:    s.Disposable,
+		VerifyURL:     lnurl.VerifyURL(s.URL+"/verify", hex.EncodeToString(paymentHash[:])),
+	})
+}
@@ -0,0 +1,34 @@
+package lnurltest
+
+import (
+	"sync"
+
+	"github.com/fiatjaf/go-lnurl"
+)
+
+// Relay is a local stand-in for Nostr relays, recording the zap receipts published to it.
+type Relay struct {
+	mu       sync.Mutex
+	receipts []PublishedReceipt
+}
+
+// PublishedReceipt is a zap receipt published to a Relay, with the relays it was meant for.
+type PublishedReceipt struct {
+	Relays  []string
+	Receipt lnurl.NostrEvent
+}
+
+// PublishZapReceipt implements lnurl.ZapPublisher.
+func (r *Relay) PublishZapReceipt(relays []string, receipt lnurl.NostrEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.receipts = append(r.receipts, PublishedReceipt{Relays: relays, Receipt: receipt})
+	return nil
+}
+
+// Receipts returns the zap receipts published so far, in order.
+func (r *Relay) Receipts() []PublishedReceipt {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]PublishedReceipt(nil), r.receipts...)
+}
@@ -0,0 +1,131 @@
+package lnurltest
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/fiatjaf/go-lnurl"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+)
+
+// WithdrawServer is a fake lnurl-withdraw service. Its parameters are served on every path
+// except /callback, which accepts invoices.
+type WithdrawServer struct {
+	Server
+
+	// Params is served as the withdrawRequest, k1 and the callback are filled in
+	// automatically if empty.
+	Params lnurl.LNURLWithdrawResponse
+
+	// CallbackFail makes only the callback respond with an ERROR with this reason.
+	CallbackFail string
+
+	// Reusable makes the link a reusable one with a balanceCheck at /balance, the
+	// balance being maxWithdrawable minus what was withdrawn so far.
+	Reusable bool
+
+	// Notifications stores the balanceNotify URLs sent to the callback, which are
+	// notified by AddBalance.
+	Notifications lnurl.BalanceNotifications
+
+	mu        sync.Mutex
+	invoices  []string
+	withdrawn int64
+}
+
+// NewWithdrawServer starts a fake lnurl-withdraw service allowing from 1 to 100000
+// satoshis to be withdrawn.
+func NewWithdrawServer() *WithdrawServer {
+	s := &WithdrawServer{
+		Params: lnurl.LNURLWithdrawResponse{
+			Tag:                "withdrawRequest",
+			K1:                 lnurl.RandomK1(),
+			MinWithdrawable:    1000,
+			MaxWithdrawable:    100000000,
+			DefaultDescription: "test withdraw",
+		},
+	}
+	s.start(s.handle)
+	return s
+}
+
+// LNURL returns the bech32-encoded lnurl of the service.
+func (s *WithdrawServer) LNURL() string {
+	return s.LNURLAt("/")
+}
+
+// Invoices returns the invoices accepted by the callback so far, in order.
+func (s *WithdrawServer) Invoices() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.invoices...)
+}
+
+// AddBalance makes msats more available to be withdrawn from a reusable link and notifies
+// the wallets that sent a balanceNotify URL.
+func (s *WithdrawServer) AddBalance(msats int64) error {
+	s.mu.Lock()
+	s.withdrawn -= msats
+	s.mu.Unlock()
+	return s.Notifications.BalanceChanged(s.Params.K1)
+}
+
+func (s *WithdrawServer) handle(w http.ResponseWriter, r *http.Request) {
+	params := s.Params
+	if params.Callback == "" {
+		params.Callback = s.URL + "/callback"
+	}
+	if s.Reusable {
+		s.mu.Lock()
+		params.MaxWithdrawable -= s.withdrawn
+		s.mu.Unlock()
+		params = params.WithBalanceCheck(s.URL + "/balance")
+	}
+
+	if r.URL.Path == "/balance" && s.Reusable {
+		lnurl.BalanceCheckHandler(func(*http.Request) (lnurl.LNURLWithdrawResponse, error) {
+			return params, nil
+		}).ServeHTTP(w, r)
+		return
+	}
+	if r.URL.Path != "/callback" {
+		respond(w, params)
+		return
+	}
+
+	if s.CallbackFail != "" {
+		respond(w, lnurl.ErrorResponse(s.CallbackFail))
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("k1") != params.K1 {
+		respond(w, lnurl.ErrorResponse("unknown k1"))
+		return
+	}
+
+	pr := query.Get("pr")
+	inv, err := decodepay.Decodepay(pr)
+	if err != nil {
+		respond(w, lnurl.ErrorResponse("invalid invoice"))
+		return
+	}
+	if inv.MSatoshi < params.MinWithdrawable || inv.MSatoshi > params.MaxWithdrawable {
+		respond(w, lnurl.ErrorResponse("amount out of bounds"))
+		return
+	}
+
+	if balanceNotify := query.Get("balanceNotify"); balanceNotify != "" {
+		if err := s.Notifications.Register(params.K1, balanceNotify); err != nil {
+			respond(w, lnurl.ErrorResponse(err.Error()))
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.invoices = append(s.invoices, pr)
+	s.withdrawn += inv.MSatoshi
+	s.mu.Unlock()
+
+	respond(w, lnurl.OkResponse())
+}
@@ -0,0 +1,213 @@
+package lnurl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// Metadata is the metadata of an lnurl-pay service. The typed fields reflect the known
+// entries, while Entries holds every entry, known or not, in their original order.
+//
+// Metadata obtained from ParseMetadata encodes back to exactly the same string as long
+// as its entries aren't changed, so the description hash of invoices stays the same.
+// Metadata built by filling the typed fields only is encoded from those fields. Once
+// Entries is set, changes should be made with Set and Add so the typed fields follow.
+type Metadata struct {
+	Description     string
+	LongDescription string
+	Image           struct {
+		DataURI string
+		Bytes   []byte
+		Ext     string
+	}
+	LightningAddress string
+	IsEmail          bool
+
+	Entries []MetadataEntry
+
+	// raw is the string the entries were parsed from and canonical is what encoding
+	// these same entries from scratch gives.
+	raw       string
+	canonical string
+}
+
+// MetadataEntry is one [mime, value] pair of the metadata.
+type MetadataEntry struct {
+	Type  string
+	Value string
+
+	// Raw is the entry as it was received. It is used instead of Type and Value when
+	// encoding, so entries that aren't [string, string] pairs are kept untouched too.
+	Raw json.RawMessage
+}
+
+// ParseMetadata parses the metadata string of an lnurl-pay service.
+func ParseMetadata(encoded string) (Metadata, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(encoded), &items); err != nil {
+		return Metadata{}, err
+	}
+
+	metadata := Metadata{Entries: make([]MetadataEntry, len(items))}
+	for i, item := range items {
+		entry := MetadataEntry{Raw: item}
+		var pair []interface{}
+		if json.Unmarshal(item, &pair) == nil {
+			if len(pair) > 0 {
+				entry.Type, _ = pair[0].(string)
+			}
+			if len(pair) > 1 {
+				entry.Value, _ = pair[1].(string)
+			}
+		}
+		metadata.Entries[i] = entry
+	}
+
+	metadata.raw = encoded
+	metadata.canonical = encodeEntries(metadata.Entries)
+	metadata.update()
+	return metadata, nil
+}
+
+// Get returns the value of the first entry of the given type.
+func (metadata Metadata) Get(typ string) (value string, ok bool) {
+	for _, entry := range metadata.entries() {
+		if entry.Type == typ {
+			return entry.Value, true
+		}
+	}
+	return "", false
+}
+
+// Set replaces the value of the first entry of the given type, removing any other entries
+// of that type, or adds an entry if there is none.
+func (metadata *Metadata) Set(typ string, value string) {
+	entries := metadata.entries()
+	metadata.Entries = make([]MetadataEntry, 0, len(entries)+1)
+	found := false
+	for _, entry := range entries {
+		if entry.Type == typ {
+			if found {
+				continue
+			}
+			entry = MetadataEntry{Type: typ, Value: value}
+			found = true
+		}
+		metadata.Entries = append(metadata.Entries, entry)
+	}
+	if !found {
+		metadata.Entries = append(metadata.Entries, MetadataEntry{Type: typ, Value: value})
+	}
+	metadata.update()
+}
+
+// Add appends an entry, even if there are others of the same type.
+func (metadata *Metadata) Add(typ string, value string) {
+	metadata.Entries = append(metadata.entries(), MetadataEntry{Type: typ, Value: value})
+	metadata.update()
+}
+
+func (metadata Metadata) Encode() string {
+	encoded := encodeEntries(metadata.entries())
+	if metadata.raw != "" && encoded == metadata.canonical {
+		return metadata.raw
+	}
+	return encoded
+}
+
+// entries returns Entries, or the entries corresponding to the typed fields if Entries
+// is nil.
+func (metadata Metadata) entries() []MetadataEntry {
+	if metadata.Entries != nil {
+		return metadata.Entries
+	}
+
+	entries := make([]MetadataEntry, 0, 4)
+	entries = append(entries, MetadataEntry{Type: "text/plain", Value: metadata.Description})
+
+	if metadata.LongDescription != "" {
+		entries = append(entries, MetadataEntry{Type: "text/long-desc", Value: metadata.LongDescription})
+	}
+
+	if metadata.Image.Bytes != nil {
+		entries = append(entries, MetadataEntry{
+			Type:  "image/" + metadata.Image.Ext + ";base64",
+			Value: base64.StdEncoding.EncodeToString(metadata.Image.Bytes),
+		})
+	} else if metadata.Image.DataURI != "" {
+		typ, value, _ := strings.Cut(strings.TrimPrefix(metadata.Image.DataURI, "data:"), ",")
+		entries = append(entries, MetadataEntry{Type: typ, Value: value})
+	}
+
+	if metadata.LightningAddress != "" {
+		tag := "text/identifier"
+		if metadata.IsEmail {
+			tag = "text/email"
+		}
+		entries = append(entries, MetadataEntry{Type: tag, Value: metadata.LightningAddress})
+	}
+
+	return entries
+}
+
+// update sets the typed fields from Entries. As with Get, when a type is repeated the
+// first entry is the one that counts.
+func (metadata *Metadata) update() {
+	metadata.Description = ""
+	metadata.LongDescription = ""
+	metadata.Image.DataURI = ""
+	metadata.Image.Bytes = nil
+	metadata.Image.Ext = ""
+	metadata.LightningAddress = ""
+	metadata.IsEmail = false
+
+	seen := make(map[string]bool)
+	for _, entry := range metadata.Entries {
+		// images and addresses fill the same fields whatever their exact type
+		field := entry.Type
+		switch entry.Type {
+		case "image/png;base64", "image/jpeg;base64":
+			field = "image"
+		case "text/email", "text/identifier":
+			field = "address"
+		}
+		if seen[field] {
+			continue
+		}
+		seen[field] = true
+
+		switch entry.Type {
+		case "text/plain":
+			metadata.Description = entry.Value
+		case "text/long-desc":
+			metadata.LongDescription = entry.Value
+		case "image/png;base64", "image/jpeg;base64":
+			metadata.Image.DataURI = "data:" + entry.Type + "," + entry.Value
+			metadata.Image.Bytes, _ = base64.StdEncoding.DecodeString(entry.Value)
+			metadata.Image.Ext = strings.TrimSuffix(strings.TrimPrefix(entry.Type, "image/"), ";base64")
+		case "text/email", "text/identifier":
+			metadata.LightningAddress = entry.Value
+			metadata.IsEmail = entry.Type == "text/email"
+		}
+	}
+}
+
+func encodeEntries(entries []MetadataEntry) string {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, entry := range entries {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if entry.Raw != nil {
+			buf.Write(entry.Raw)
+			continue
+		}
+		pair, _ := json.Marshal([]string{entry.Type, entry.Value})
+		buf.Write(pair)
+	}
+	buf.WriteByte(']')
+	return buf.String()
+}
@@ -0,0 +1,116 @@
+package lnurl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestMetadata(t *testing.T) {
+	encoded := `[ ["text/plain", "café"],["text/tag","coffee"], ["image/png;base64","iVBORw0KGgo="],` +
+		`["image/jpeg;base64","/9j/"],[1,2,3],["text/identifier","a@b.c"],["text/plain","tea"]]`
+
+	metadata, err := ParseMetadata(encoded)
+	if err != nil {
+		t.Fatalf("ParseMetadata() error = %v", err)
+	}
+	if metadata.Encode() != encoded {
+		t.Errorf("Encode() got = %s, want %s", metadata.Encode(), encoded)
+	}
+	if len(metadata.Entries) != 7 || metadata.Description != "café" || metadata.Image.Ext != "png" ||
+		metadata.LightningAddress != "a@b.c" || metadata.IsEmail {
+		t.Errorf("ParseMetadata() got = %+v", metadata)
+	}
+	if tag, ok := metadata.Get("text/tag"); !ok || tag != "coffee" {
+		t.Errorf("Get() got = %v, %v", tag, ok)
+	}
+	if description, _ := metadata.Get("text/plain"); description != metadata.Description {
+		t.Errorf("Get() got = %s, while Description is %s", description, metadata.Description)
+	}
+
+	metadata.Set("text/plain", "tea")
+	metadata.Add("text/tag", "tea")
+	want := `[["text/plain","tea"],["text/tag","coffee"],["image/png;base64","iVBORw0KGgo="],` +
+		`["image/jpeg;base64","/9j/"],[1,2,3],["text/identifier","a@b.c"],["text/tag","tea"]]`
+	if metadata.Encode() != want || metadata.Description != "tea" {
+		t.Errorf("Encode() after changes got = %s, want %s", metadata.Encode(), want)
+	}
+
+	built := Metadata{Description: "a coffee", LightningAddress: "a@b.c", IsEmail: true}
+	if encoded := built.Encode(); encoded != `[["text/plain","a coffee"],["text/email","a@b.c"]]` {
+		t.Errorf("Encode() from typed fields got = %s", encoded)
+	}
+	built.Add("text/tag", "coffee")
+	if encoded := built.Encode(); encoded != `[["text/plain","a coffee"],["text/email","a@b.c"],["text/tag","coffee"]]` {
+		t.Errorf("Encode() after Add() got = %s", encoded)
+	}
+}
+
+func TestMetadataValidate(t *testing.T) {
+	var tiny bytes.Buffer
+	png.Encode(&tiny, image.NewGray(image.Rect(0, 0, 4, 4)))
+	tinyPNG := base64.StdEncoding.EncodeToString(tiny.Bytes())
+
+	for _, tt := range []struct {
+		metadata string
+		wantErr  string
+	}{
+		{`[["text/plain","a"],["image/png;base64","` + tinyPNG + `"],["text/tag","x"]]`, ""},
+		{`[["text/long-desc","a"]]`, "exactly one text/plain"},
+		{`[["text/plain","a"],["text/plain","b"]]`, "exactly one text/plain"},
+		{`[["text/plain","a"],["text/plain"]]`, "not a [mime, string] pair"},
+		{`[["text/plain","a"],["image/jpeg;base64","` + tinyPNG + `"]]`, "doesn't contain a jpeg image"},
+		{`[["text/plain","a"],["image/png;base64","%%%"]]`, "not valid base64"},
+		{`[["text/plain","a"],["image/gif;base64","R0lGOD"]]`, "image type"},
+		{`[["text/plain","a"],["image/png;base64","` + tinyPNG + `"],["image/png;base64","` + tinyPNG + `"]]`, "at most one image"},
+	} {
+		metadata, _ := ParseMetadata(tt.metadata)
+		err := metadata.Validate()
+		if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("Validate(%s) error = %v, want %q", tt.metadata, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMetadataSetImage(t *testing.T) {
+	noise := image.NewNRGBA(image.Rect(0, 0, 1200, 700))
+	for i := range noise.Pix {
+		noise.Pix[i] = byte(rand.Intn(256))
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, noise)
+
+	metadata := Metadata{Description: "noise"}
+	metadata.Add("image/png;base64", "old")
+	if err := metadata.SetImage(buf.Bytes()); err != nil {
+		t.Fatalf("SetImage() error = %v", err)
+	}
+	if err := metadata.Validate(); err != nil {
+		t.Errorf("Validate() after SetImage() error = %v", err)
+	}
+
+	config, _, _ := image.DecodeConfig(bytes.NewReader(metadata.Image.Bytes))
+	if config.Width != MaxImageDimension || config.Height != 298 || len(metadata.Entries) != 2 {
+		t.Errorf("SetImage() got %dx%d %s with %d entries", config.Width, config.Height,
+			metadata.Image.Ext, len(metadata.Entries))
+	}
+
+	// a tiny PNG declaring 100000x100000 pixels must not be decoded
+	buf.Reset()
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	bomb := buf.Bytes()
+	binary.BigEndian.PutUint32(bomb[16:], 100000)
+	binary.BigEndian.PutUint32(bomb[20:], 100000)
+	crc := crc32.NewIEEE()
+	crc.Write(bomb[12:29])
+	binary.BigEndian.PutUint32(bomb[29:], crc.Sum32())
+	if err := metadata.SetImage(bomb); err == nil || !strings.Contains(err.Error(), "pixels") {
+		t.Errorf("SetImage() of a decompression bomb error = %v", err)
+	}
+}
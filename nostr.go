@@ -0,0 +1,163 @@
+package lnurl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+const (
+	// KindZapRequest is the kind of the event a wallet sends to the callback to zap.
+	KindZapRequest = 9734
+	// KindZapReceipt is the kind of the event the service publishes once a zap is paid.
+	KindZapReceipt = 9735
+)
+
+// NostrEvent is a Nostr event as defined in NIP-01, only as much as needed for zaps
+// (NIP-57).
+type NostrEvent struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// NewZapRequest returns an unsigned zap request for msats to the Nostr pubkey recipient
+// through the given bech32-encoded lnurl, asking for the receipt to be published to
+// relays. The content is the zap comment.
+func NewZapRequest(recipient string, msats int64, lnurl string, relays []string, content string) NostrEvent {
+	return NostrEvent{
+		CreatedAt: time.Now().Unix(),
+		Kind:      KindZapRequest,
+		Tags: [][]string{
+			append([]string{"relays"}, relays...),
+			{"amount", strconv.FormatInt(msats, 10)},
+			{"lnurl", lnurl},
+			{"p", recipient},
+		},
+		Content: content,
+	}
+}
+
+// Tag returns the first tag with the given name, or nil.
+func (evt NostrEvent) Tag(name string) []string {
+	for _, tag := range evt.Tags {
+		if len(tag) > 0 && tag[0] == name {
+			return tag
+		}
+	}
+	return nil
+}
+
+// Serialize returns the canonical serialization of the event that its id is the hash of.
+func (evt NostrEvent) Serialize() []byte {
+	dst := make([]byte, 0, 100+len(evt.Content)+len(evt.Tags)*80)
+	dst = append(dst, "[0,\""...)
+	dst = append(dst, evt.PubKey...)
+	dst = append(dst, "\","...)
+	dst = strconv.AppendInt(dst, evt.CreatedAt, 10)
+	dst = append(dst, ',')
+	dst = strconv.AppendInt(dst, int64(evt.Kind), 10)
+	dst = append(dst, ",["...)
+	for i, tag := range evt.Tags {
+		if i > 0 {
+			dst = append(dst, ',')
+		}
+		dst = append(dst, '[')
+		for j, item := range tag {
+			if j > 0 {
+				dst = append(dst, ',')
+			}
+			dst = appendNostrString(dst, item)
+		}
+		dst = append(dst, ']')
+	}
+	dst = append(dst, "],"...)
+	dst = appendNostrString(dst, evt.Content)
+	return append(dst, ']')
+}
+
+// GetID returns the id the event should have, the hex-encoded hash of its serialization.
+func (evt NostrEvent) GetID() string {
+	hash := sha256.Sum256(evt.Serialize())
+	return hex.EncodeToString(hash[:])
+}
+
+// Sign sets the pubkey, id and signature of the event.
+func (evt *NostrEvent) Sign(key *btcec.PrivateKey) error {
+	evt.PubKey = hex.EncodeToString(schnorr.SerializePubKey(key.PubKey()))
+	hash := sha256.Sum256(evt.Serialize())
+
+	sig, err := schnorr.Sign(key, hash[:])
+	if err != nil {
+		return err
+	}
+
+	evt.ID = hex.EncodeToString(hash[:])
+	evt.Sig = hex.EncodeToString(sig.Serialize())
+	return nil
+}
+
+// CheckSignature checks the id and the signature of the event.
+func (evt NostrEvent) CheckSignature() error {
+	if evt.ID != evt.GetID() {
+		return errors.New("event id doesn't match its contents")
+	}
+
+	bpubkey, err := hex.DecodeString(evt.PubKey)
+	if err != nil {
+		return errors.New("pubkey is not valid hex")
+	}
+	pubkey, err := schnorr.ParsePubKey(bpubkey)
+	if err != nil {
+		return errors.New("failed to parse pubkey: " + err.Error())
+	}
+
+	bsig, err := hex.DecodeString(evt.Sig)
+	if err != nil {
+		return errors.New("signature is not valid hex")
+	}
+	sig, err := schnorr.ParseSignature(bsig)
+	if err != nil {
+		return errors.New("failed to parse signature: " + err.Error())
+	}
+
+	id, _ := hex.DecodeString(evt.ID)
+	if !sig.Verify(id, pubkey) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// appendNostrString appends s as a JSON string escaped as NIP-01 says: only quotes,
+// backslashes and the \n, \r, \t, \b and \f control characters are escaped.
+func appendNostrString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			dst = append(dst, '\\', c)
+		case '\n':
+			dst = append(dst, '\\', 'n')
+		case '\r':
+			dst = append(dst, '\\', 'r')
+		case '\t':
+			dst = append(dst, '\\', 't')
+		case '\b':
+			dst = append(dst, '\\', 'b')
+		case '\f':
+			dst = append(dst, '\\', 'f')
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, '"')
+}
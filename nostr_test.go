@@ -0,0 +1,65 @@
+package lnurl
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+func TestNostrEvent(t *testing.T) {
+	evt := NostrEvent{
+		PubKey:    strings.Repeat("ab", 32),
+		CreatedAt: 1700000000,
+		Kind:      KindZapRequest,
+		Tags:      [][]string{{"p", "x"}, {"relays", "wss://a", "wss://b"}},
+		Content:   "a \"zap\"\n\tfor <you> ⚡\\",
+	}
+	want := `[0,"` + strings.Repeat("ab", 32) + `",1700000000,9734,[["p","x"],["relays","wss://a","wss://b"]],"a \"zap\"\n\tfor <you> ⚡\\"]`
+	if got := string(evt.Serialize()); got != want {
+		t.Errorf("Serialize() got = %s, want %s", got, want)
+	}
+
+	key, _ := btcec.PrivKeyFromBytes(append(make([]byte, 31), 3))
+	if err := evt.Sign(key); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if evt.PubKey != "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9" {
+		t.Errorf("Sign() set pubkey %s", evt.PubKey)
+	}
+	if err := evt.CheckSignature(); err != nil {
+		t.Errorf("CheckSignature() error = %v", err)
+	}
+
+	evt.Content = "changed"
+	if err := evt.CheckSignature(); err == nil {
+		t.Errorf("CheckSignature() accepted a changed event")
+	}
+	evt.ID = evt.GetID()
+	if err := evt.CheckSignature(); err == nil || err.Error() != "invalid signature" {
+		t.Errorf("CheckSignature() of a changed event with a new id error = %v", err)
+	}
+}
+
+func TestCallZapChecks(t *testing.T) {
+	key, _ := btcec.NewPrivateKey()
+	params := LNURLPayParams{
+		Callback:    "https://service.com/callback",
+		AllowsNostr: true,
+		NostrPubkey: hex.EncodeToString(make([]byte, 32)),
+	}
+
+	zap := NewZapRequest(params.NostrPubkey, 5000, "lnurl1", []string{"wss://relay"}, "")
+	if _, err := params.CallZap(5000, zap); err == nil || !strings.Contains(err.Error(), "invalid zap request") {
+		t.Errorf("CallZap() with an unsigned zap request error = %v", err)
+	}
+	zap.Sign(key)
+	if _, err := params.CallZap(6000, zap); err == nil || !strings.Contains(err.Error(), "not 6000") {
+		t.Errorf("CallZap() with a different amount error = %v", err)
+	}
+	params.AllowsNostr = false
+	if _, err := params.CallZap(5000, zap); err == nil {
+		t.Errorf("CallZap() to a service that doesn't allow zaps succeeded")
+	}
+}
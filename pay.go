@@ -1,15 +1,17 @@
 package lnurl
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/url"
 	"strconv"
-	"strings"
 	"time"
+	"unicode/utf8"
 
 	decodepay "github.com/nbd-wtf/ln-decodepay"
 )
@@ -28,20 +30,50 @@ func CallPay(
 	comment string,
 	payerdata *PayerDataValues,
 ) (*LNURLPayValues, error) {
+	return callPay(callback, payCall{msats: msats, comment: comment, payerdata: payerdata})
+}
+
+// payCall is what callPay sends to the callback of a service.
+type payCall struct {
+	msats      int64
+	comment    string
+	payerdata  *PayerDataValues
+	zapRequest *NostrEvent
+
+	// if currency is set, amount, in its smallest unit, is sent instead of msats and the
+	// service picks the amount of the invoice. As UMA does, it is sent as amount=<n>.<code>,
+	// and the receiver gets that same currency, so convert isn't sent.
+	amount   int64
+	currency string
+}
+
+func callPay(callback *url.URL, call payCall) (*LNURLPayValues, error) {
+	callback = cloneURL(callback)
 	qs := callback.Query()
-	qs.Set("amount", strconv.FormatInt(msats, 10))
+	if call.currency != "" {
+		qs.Set("amount", strconv.FormatInt(call.amount, 10)+"."+call.currency)
+	} else {
+		qs.Set("amount", strconv.FormatInt(call.msats, 10))
+	}
 
-	if comment != "" {
-		qs.Set("comment", comment)
+	if call.comment != "" {
+		qs.Set("comment", call.comment)
 	}
 
 	var payerdataJSON string
-	if payerdata != nil {
-		j, _ := json.Marshal(payerdata)
+	if call.payerdata != nil {
+		j, _ := json.Marshal(call.payerdata)
 		payerdataJSON = string(j)
 		qs.Set("payerdata", payerdataJSON)
 	}
 
+	var zapRequestJSON string
+	if call.zapRequest != nil {
+		j, _ := json.Marshal(call.zapRequest)
+		zapRequestJSON = string(j)
+		qs.Set("nostr", zapRequestJSON)
+	}
+
 	callback.RawQuery = qs.Encode()
 	resp, err := actualClient.Get(callback.String())
 	if err != nil {
@@ -72,13 +104,21 @@ func CallPay(
 	values.ParsedInvoice = inv
 	values.PayerDataJSON = payerdataJSON
 
-	if int64(inv.MSatoshi) != msats {
+	if call.currency == "" && int64(inv.MSatoshi) != call.msats {
 		return nil, fmt.Errorf("got invoice with wrong amount (wanted %d, got %d)",
-			msats,
+			call.msats,
 			inv.MSatoshi,
 		)
 	}
 
+	if call.zapRequest != nil {
+		// NIP-57: the invoice commits to the zap request instead of the metadata
+		hash := sha256.Sum256([]byte(zapRequestJSON))
+		if inv.DescriptionHash != hex.EncodeToString(hash[:]) {
+			return nil, errors.New("got invoice with a description hash that isn't of the zap request")
+		}
+	}
+
 	return &values, nil
 }
 
@@ -125,20 +165,17 @@ type LNURLPayParams struct {
 	EncodedMetadata string         `json:"metadata"`
 	CommentAllowed  int64          `json:"commentAllowed"`
 	PayerData       *PayerDataSpec `json:"payerData,omitempty"`
+	WithdrawLink    string         `json:"withdrawLink,omitempty"`
+	AllowsNostr     bool           `json:"allowsNostr,omitempty"`
+	NostrPubkey     string         `json:"nostrPubkey,omitempty"`
 
-	Metadata Metadata `json:"-"`
-}
+	// Currencies lists the other currencies amounts can be sent in, see CallCurrency.
+	// UMAVersion and Compliance are set by UMA services.
+	Currencies []Currency             `json:"currencies,omitempty"`
+	UMAVersion string                 `json:"umaVersion,omitempty"`
+	Compliance *UMAReceiverCompliance `json:"compliance,omitempty"`
 
-type Metadata struct {
-	Description     string
-	LongDescription string
-	Image           struct {
-		DataURI string
-		Bytes   []byte
-		Ext     string
-	}
-	LightningAddress string
-	IsEmail          bool
+	Metadata Metadata `json:"-"`
 }
 
 type PayerDataSpec struct {
@@ -147,6 +184,7 @@ type PayerDataSpec struct {
 	LightningAddress *PayerDataItemSpec    `json:"identifier"`
 	Email            *PayerDataItemSpec    `json:"email"`
 	KeyAuth          *PayerDataKeyAuthSpec `json:"auth"`
+	Compliance       *PayerDataItemSpec    `json:"compliance,omitempty"`
 }
 
 type PayerDataItemSpec struct {
@@ -164,6 +202,11 @@ type LNURLPayValues struct {
 	Routes        interface{}    `json:"routes"` // ignored
 	PR            string         `json:"pr"`
 	Disposable    *bool          `json:"disposable,omitempty"`
+	VerifyURL     string         `json:"verify,omitempty"`
+
+	// Converted and PayeeData are returned by UMA services.
+	Converted *Converted `json:"converted,omitempty"`
+	PayeeData *PayeeData `json:"payeeData,omitempty"`
 
 	ParsedInvoice decodepay.Bolt11 `json:"-"`
 	PayerDataJSON string           `json:"-"`
@@ -175,6 +218,7 @@ type PayerDataValues struct {
 	LightningAddress string                  `json:"identifier,omitempty"`
 	Email            string                  `json:"email,omitempty"`
 	KeyAuth          *PayerDataKeyAuthValues `json:"auth,omitempty"`
+	Compliance       *UMAPayerCompliance     `json:"compliance,omitempty"`
 }
 
 type PayerDataKeyAuthValues struct {
@@ -197,8 +241,15 @@ func (params LNURLPayParams) CallbackURL() *url.URL {
 	return parsed
 }
 
+// Reusable tells if the pay link can be saved and paid again later. Per LUD-11 that is
+// only the case if the service explicitly set disposable to false.
+func (values LNURLPayValues) Reusable() bool {
+	return values.Disposable != nil && !*values.Disposable
+}
+
 func (s PayerDataSpec) Exists() bool {
-	return s.FreeName != nil || s.PubKey != nil || s.LightningAddress != nil || s.Email != nil || s.KeyAuth != nil
+	return s.FreeName != nil || s.PubKey != nil || s.LightningAddress != nil || s.Email != nil || s.KeyAuth != nil ||
+		s.Compliance != nil
 }
 
 func (sa *SuccessAction) Decipher(preimage []byte) (content string, err error) {
@@ -238,35 +289,11 @@ func HandlePay(raw []byte) (LNURLParams, error) {
 
 func (params *LNURLPayParams) Normalize() error {
 	// parse metadata
-	var array []interface{}
-	if err := json.Unmarshal([]byte(params.EncodedMetadata), &array); err != nil {
+	metadata, err := ParseMetadata(params.EncodedMetadata)
+	if err != nil {
 		return err
 	}
-	for _, item := range array {
-		entry, _ := item.([]interface{})
-		if len(entry) <= 1 {
-			continue
-		}
-
-		switch entry[0] {
-		case "text/plain":
-			params.Metadata.Description, _ = entry[1].(string)
-		case "text/long-desc":
-			params.Metadata.LongDescription, _ = entry[1].(string)
-		case "image/png;base64", "image/jpeg;base64":
-			k, _ := entry[0].(string)
-			v, _ := entry[1].(string)
-
-			params.Metadata.Image.DataURI = "data:" + k + "," + v
-			params.Metadata.Image.Bytes, _ = base64.StdEncoding.DecodeString(v)
-			params.Metadata.Image.Ext = strings.Split(strings.Split(k, "/")[1], ";")[0]
-		case "text/email", "text/identifier":
-			params.Metadata.LightningAddress, _ = entry[1].(string)
-			if entry[0].(string) == "text/email" {
-				params.Metadata.IsEmail = true
-			}
-		}
-	}
+	params.Metadata = metadata
 
 	// parse url
 	callbackURL, err := url.Parse(params.Callback)
@@ -280,6 +307,15 @@ func (params *LNURLPayParams) Normalize() error {
 	callbackURL.RawQuery = qs.Encode()
 	params.Callback = callbackURL.String()
 
+	// invalid currencies are dropped, the service can still be paid in msats
+	currencies := params.Currencies[:0]
+	for _, currency := range params.Currencies {
+		if currency.Validate() == nil {
+			currencies = append(currencies, currency)
+		}
+	}
+	params.Currencies = currencies
+
 	return nil
 }
 
@@ -288,6 +324,33 @@ func (params LNURLPayParams) Call(
 	comment string,
 	payerdata *PayerDataValues,
 ) (*LNURLPayValues, error) {
+	payerdata, err := params.checkCall(comment, payerdata)
+	if err != nil {
+		return nil, err
+	}
+
+	return CallPay(
+		params.MetadataEncoded(),
+		params.CallbackURL(),
+		msats,
+		comment,
+		payerdata,
+	)
+}
+
+// checkCall checks the comment and payerdata can be sent to the service, and returns the
+// payerdata to send, nil if the service doesn't ask for any.
+func (params LNURLPayParams) checkCall(comment string, payerdata *PayerDataValues) (*PayerDataValues, error) {
+	if comment != "" {
+		if params.CommentAllowed <= 0 {
+			return nil, fmt.Errorf("comments are not allowed")
+		}
+		if n := utf8.RuneCountInString(comment); int64(n) > params.CommentAllowed {
+			return nil, fmt.Errorf("comment is %d characters long, the maximum is %d",
+				n, params.CommentAllowed)
+		}
+	}
+
 	if params.PayerData == nil || !params.PayerData.Exists() {
 		payerdata = nil
 	} else {
@@ -316,15 +379,36 @@ func (params LNURLPayParams) Call(
 			(payerdata == nil || payerdata.KeyAuth == nil) {
 			return nil, fmt.Errorf("auth is mandatory")
 		}
+		if params.PayerData.Compliance != nil &&
+			params.PayerData.Compliance.Mandatory &&
+			(payerdata == nil || payerdata.Compliance == nil) {
+			return nil, fmt.Errorf("compliance data is mandatory")
+		}
 	}
 
-	return CallPay(
-		params.MetadataEncoded(),
-		params.CallbackURL(),
-		msats,
-		comment,
-		payerdata,
-	)
+	return payerdata, nil
+}
+
+// CallZap is like Call, but sends a signed zap request (NIP-57) instead of a comment and
+// payerdata. The service must allow Nostr and the invoice must commit to the zap request.
+func (params LNURLPayParams) CallZap(msats int64, zapRequest NostrEvent) (*LNURLPayValues, error) {
+	if !params.AllowsNostr {
+		return nil, errors.New("service doesn't allow zaps")
+	}
+	if b, err := hex.DecodeString(params.NostrPubkey); err != nil || len(b) != 32 {
+		return nil, errors.New("service has an invalid nostrPubkey")
+	}
+	if zapRequest.Kind != KindZapRequest {
+		return nil, fmt.Errorf("zap request must be of kind %d, not %d", KindZapRequest, zapRequest.Kind)
+	}
+	if amount := zapRequest.Tag("amount"); len(amount) > 1 && amount[1] != strconv.FormatInt(msats, 10) {
+		return nil, fmt.Errorf("zap request is for %s msats, not %d", amount[1], msats)
+	}
+	if err := zapRequest.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid zap request: %w", err)
+	}
+
+	return callPay(params.CallbackURL(), payCall{msats: msats, zapRequest: &zapRequest})
 }
 
 func (params LNURLPayParams) MetadataEncoded() string {
@@ -335,31 +419,48 @@ func (params LNURLPayParams) MetadataEncoded() string {
 	return params.EncodedMetadata
 }
 
-func (metadata Metadata) Encode() string {
-	raw := make([]interface{}, 0, 5)
-	raw = append(raw, []string{"text/plain", metadata.Description})
+// SignPayerAuth signs the k1 of the auth payerdata item of LUD-18 with the linking key
+// for the domain of the service, as would be done for lnurl-auth.
+func (params LNURLPayParams) SignPayerAuth(signer LinkingKeySigner) (*PayerDataKeyAuthValues, error) {
+	if params.PayerData == nil || params.PayerData.KeyAuth == nil {
+		return nil, errors.New("service doesn't ask for auth payerdata")
+	}
 
-	if metadata.LongDescription != "" {
-		raw = append(raw, []string{"text/long-desc", metadata.LongDescription})
+	k1, err := hex.DecodeString(params.PayerData.KeyAuth.K1)
+	if err != nil || len(k1) != 32 {
+		return nil, errors.New("k1 is not a valid 32-byte hex-encoded string.")
 	}
 
-	if metadata.Image.Bytes != nil {
-		raw = append(raw, []string{
-			"image/" + metadata.Image.Ext + ";base64",
-			base64.StdEncoding.EncodeToString(metadata.Image.Bytes),
-		})
-	} else if metadata.Image.DataURI != "" {
-		raw = append(raw, strings.SplitN(metadata.Image.DataURI[5:], ",", 2))
+	callback := params.CallbackURL()
+	if callback == nil || callback.Hostname() == "" {
+		return nil, errors.New("callback is not a valid URL")
 	}
 
-	if metadata.LightningAddress != "" {
-		tag := "text/identifier"
-		if metadata.IsEmail {
-			tag = "text/email"
-		}
-		raw = append(raw, []string{tag, metadata.LightningAddress})
+	sig, pubkey, err := signer.SignWithLinkingKey(callback.Hostname(), k1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign k1: %w", err)
+	}
+
+	return &PayerDataKeyAuthValues{
+		K1:  params.PayerData.KeyAuth.K1,
+		Sig: hex.EncodeToString(sig.Serialize()),
+		Key: hex.EncodeToString(pubkey.SerializeCompressed()),
+	}, nil
+}
+
+// Verify checks the auth payerdata item sent by a wallet, k1 being the one the service
+// issued in its payerData.
+func (values PayerDataKeyAuthValues) Verify(k1 string) error {
+	if values.K1 != k1 {
+		return errors.New("k1 doesn't match the one issued")
 	}
 
-	enc, _ := json.Marshal(raw)
-	return string(enc)
+	ok, err := VerifySignature(values.K1, values.Sig, values.Key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
 }
@@ -0,0 +1,80 @@
+package lnurl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+func TestSignPayerAuth(t *testing.T) {
+	k1 := RandomK1()
+	var s *httptest.Server
+	s = newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := LNURLPayParams{
+			Tag:             "payRequest",
+			Callback:        s.URL + "/callback",
+			MinSendable:     1000,
+			MaxSendable:     1000000,
+			EncodedMetadata: `[["text/plain","test"]]`,
+			PayerData:       &PayerDataSpec{KeyAuth: &PayerDataKeyAuthSpec{Mandatory: true, K1: k1}},
+		}
+		if r.URL.Path != "/callback" {
+			json.NewEncoder(w).Encode(params)
+			return
+		}
+
+		var payerdata PayerDataValues
+		json.Unmarshal([]byte(r.URL.Query().Get("payerdata")), &payerdata)
+		if payerdata.KeyAuth == nil {
+			json.NewEncoder(w).Encode(ErrorResponse("auth is mandatory"))
+			return
+		}
+		if err := payerdata.KeyAuth.Verify(k1); err != nil {
+			json.NewEncoder(w).Encode(ErrorResponse("invalid auth: " + err.Error()))
+			return
+		}
+		hash := sha256.Sum256([]byte(params.EncodedMetadata + r.URL.Query().Get("payerdata")))
+		pr, _ := testInvoice(5000, hash[:])
+		json.NewEncoder(w).Encode(LNURLPayValues{LNURLResponse: OkResponse(), PR: pr})
+	}))
+
+	_, params, err := HandleLNURL(s.URL)
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	pay := params.(LNURLPayParams)
+
+	master, _ := hdkeychain.NewMaster(bytes.Repeat([]byte{1}, 32), &chaincfg.MainNetParams)
+	keys := HDLinkingKeys{Master: master}
+	auth, err := pay.SignPayerAuth(keys)
+	if err != nil {
+		t.Fatalf("SignPayerAuth() error = %v", err)
+	}
+
+	// the key is the one for the domain, regardless of the port the service is on
+	key, _ := keys.LinkingKey("127.0.0.1")
+	if auth.Key != hex.EncodeToString(key.PubKey().SerializeCompressed()) {
+		t.Errorf("SignPayerAuth() didn't use the key for the callback domain")
+	}
+
+	if _, err := pay.Call(5000, "", &PayerDataValues{KeyAuth: auth}); err != nil {
+		t.Errorf("Call() with auth error = %v", err)
+	}
+	if _, err := pay.Call(5000, "", nil); err == nil {
+		t.Errorf("Call() without mandatory auth succeeded")
+	}
+
+	k1 = RandomK1()
+	if _, err := pay.Call(5000, "", &PayerDataValues{KeyAuth: auth}); err == nil ||
+		!strings.Contains(err.Error(), "k1") {
+		t.Errorf("Call() with auth for another k1 error = %v", err)
+	}
+}
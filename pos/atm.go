@@ -0,0 +1,167 @@
+package pos
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/go-lnurl"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+)
+
+// ErrWithdrawn is returned for links that were already withdrawn.
+var ErrWithdrawn = errors.New("this link was already withdrawn")
+
+// LinkStore remembers the ATM links that were withdrawn.
+type LinkStore interface {
+	// Claim marks the link with the given id as withdrawn, or returns ErrWithdrawn if it
+	// already was. It must be atomic.
+	Claim(id string) error
+
+	Claimed(id string) (bool, error)
+}
+
+// MemoryLinks is a LinkStore in memory. The zero value is ready to use.
+type MemoryLinks struct {
+	mu      sync.Mutex
+	claimed map[string]bool
+}
+
+func (m *MemoryLinks) Claim(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.claimed[id] {
+		return ErrWithdrawn
+	}
+	if m.claimed == nil {
+		m.claimed = make(map[string]bool)
+	}
+	m.claimed[id] = true
+	return nil
+}
+
+func (m *MemoryLinks) Claimed(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.claimed[id], nil
+}
+
+// WithdrawTimeout is how long the k1 of a withdrawRequest served by an ATM stays valid.
+var WithdrawTimeout = 10 * time.Minute
+
+// ATM serves the links of registered ATMs, devices that take cash and show an lnurlw link
+// for its value, made just like the links of points of sale but with the amount to be
+// withdrawn. The PIN of the payload is only a nonce for them. The links are Handler URLs
+// ending in the ID of the device, and each can only be withdrawn once.
+type ATM struct {
+	// Callback is the URL CallbackHandler is served at.
+	Callback string
+
+	// Convert returns the msats worth amount in the smallest unit of currency. It is only
+	// needed for devices that don't take satoshis.
+	Convert func(currency string, amount uint64) (int64, error)
+
+	// Pay pays pr, an invoice of msats, for a withdrawal from device. The link is claimed
+	// before, so it stays withdrawn even if Pay fails, as the payment may still go through.
+	Pay func(device Device, msats int64, pr string) error
+
+	Links LinkStore
+
+	registry
+	pending map[string]pendingWithdraw
+}
+
+type pendingWithdraw struct {
+	link    link
+	msats   int64
+	expires time.Time
+}
+
+// Handler serves the links of the devices with a withdrawRequest for exactly the amount
+// of the link.
+func (atm *ATM) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		link, err := atm.decode(r)
+		if err != nil {
+			respond(w, lnurl.ErrorResponse(err.Error()))
+			return
+		}
+		if claimed, err := atm.Links.Claimed(link.id); err != nil || claimed {
+			respond(w, lnurl.ErrorResponse(ErrWithdrawn.Error()))
+			return
+		}
+		msats, err := toMsats(atm.Convert, link.device, link.payload.Amount)
+		if err != nil {
+			respond(w, lnurl.ErrorResponse(err.Error()))
+			return
+		}
+
+		k1 := lnurl.RandomK1()
+		now := time.Now()
+		atm.mu.Lock()
+		if atm.pending == nil {
+			atm.pending = make(map[string]pendingWithdraw)
+		}
+		for k, pending := range atm.pending {
+			if now.After(pending.expires) {
+				delete(atm.pending, k)
+			}
+		}
+		atm.pending[k1] = pendingWithdraw{link: link, msats: msats, expires: now.Add(WithdrawTimeout)}
+		atm.mu.Unlock()
+
+		description := link.device.Description
+		if description == "" {
+			description = "Withdrawal from " + link.device.ID
+		}
+		respond(w, lnurl.LNURLWithdrawResponse{
+			LNURLResponse:      lnurl.OkResponse(),
+			Tag:                "withdrawRequest",
+			K1:                 k1,
+			Callback:           atm.Callback,
+			MinWithdrawable:    msats,
+			MaxWithdrawable:    msats,
+			DefaultDescription: description,
+		})
+	})
+}
+
+// CallbackHandler serves the callback of the withdrawRequests, paying the invoice if the
+// link wasn't withdrawn yet.
+func (atm *ATM) CallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		k1 := query.Get("k1")
+		atm.mu.Lock()
+		pending, ok := atm.pending[k1]
+		delete(atm.pending, k1)
+		atm.mu.Unlock()
+		if !ok || time.Now().After(pending.expires) {
+			respond(w, lnurl.ErrorResponse("Unknown or expired k1"))
+			return
+		}
+
+		pr := query.Get("pr")
+		inv, err := decodepay.Decodepay(pr)
+		if err != nil {
+			respond(w, lnurl.ErrorResponse("Invalid invoice: "+err.Error()))
+			return
+		}
+		if inv.MSatoshi != pending.msats {
+			respond(w, lnurl.ErrorResponse(fmt.Sprintf("Invoice must be of %d msats", pending.msats)))
+			return
+		}
+
+		if err := atm.Links.Claim(pending.link.id); err != nil {
+			respond(w, lnurl.ErrorResponse(err.Error()))
+			return
+		}
+		if err := atm.Pay(pending.link.device, pending.msats, pr); err != nil {
+			respond(w, lnurl.ErrorResponse(err.Error()))
+			return
+		}
+		respond(w, lnurl.OkResponse())
+	})
+}
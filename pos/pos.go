@@ -0,0 +1,201 @@
+// Package pos serves the links of offline LNURLPoS devices. A device shares a secret key
+// with the service and, without being online, turns every sale into an lnurl-pay link
+// carrying in its p parameter the amount and a PIN, encrypted and authenticated with that
+// key. Once the customer pays the link, the PIN is revealed to them, and the merchant
+// knows the sale was paid when the PIN matches the one the device shows.
+//
+// ATMs following the same LNURL-device pattern make lnurlw links the same way for the
+// cash they take, and are served by ATM.
+package pos
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fiatjaf/go-lnurl"
+)
+
+const (
+	// VariantXOR is the encryption used by the LNbits devices: the payload is XORed with
+	// HMAC-SHA256(key, "Round secret:" || nonce), which limits it to 32 bytes.
+	VariantXOR byte = 1
+
+	// VariantAES encrypts the payload with AES-256-CBC instead, with the same round
+	// secret as the key and the 16-byte nonce as the IV.
+	VariantAES byte = 2
+)
+
+// Payload is what a device puts in a link.
+type Payload struct {
+	PIN uint64
+
+	// Amount is in the smallest unit of the currency of the device, like cents, or in
+	// satoshis.
+	Amount uint64
+}
+
+// Decrypt authenticates and decrypts the p parameter of a link with the key of the
+// device. It is made of a variant byte, the nonce and the encrypted payload, each
+// prefixed by its length, and the first bytes, at least 8, of
+// HMAC-SHA256(key, "Data:" || everything before them).
+func Decrypt(key []byte, p []byte) (Payload, error) {
+	r := bytes.NewReader(p)
+	variant, err := r.ReadByte()
+	if err != nil {
+		return Payload{}, errors.New("payload is empty")
+	}
+	nonce, err := readPrefixed(r)
+	if err != nil {
+		return Payload{}, errors.New("missing nonce bytes")
+	}
+	encrypted, err := readPrefixed(r)
+	if err != nil {
+		return Payload{}, errors.New("missing payload bytes")
+	}
+
+	mac := p[len(p)-r.Len():]
+	if len(mac) < 8 {
+		return Payload{}, errors.New("hmac is too short")
+	}
+	expected := hmacSum(key, "Data:", p[:len(p)-len(mac)])
+	if !hmac.Equal(mac, expected[:min(len(mac), len(expected))]) {
+		return Payload{}, errors.New("invalid hmac")
+	}
+
+	secret := hmacSum(key, "Round secret:", nonce)
+	var plain []byte
+	switch variant {
+	case VariantXOR:
+		if len(nonce) < 8 {
+			return Payload{}, errors.New("nonce is too short")
+		}
+		if len(encrypted) > len(secret) {
+			return Payload{}, errors.New("payload is too long for xor encryption")
+		}
+		plain = make([]byte, len(encrypted))
+		for i := range encrypted {
+			plain[i] = encrypted[i] ^ secret[i]
+		}
+	case VariantAES:
+		if len(nonce) != aes.BlockSize {
+			return Payload{}, errors.New("nonce must be 16 bytes long")
+		}
+		if plain, err = lnurl.AESDecipher(secret, encrypted, nonce); err != nil {
+			return Payload{}, err
+		}
+	default:
+		return Payload{}, fmt.Errorf("unknown variant %d", variant)
+	}
+
+	pr := bytes.NewReader(plain)
+	var payload Payload
+	if payload.PIN, err = readCompactSize(pr); err != nil {
+		return Payload{}, errors.New("invalid pin")
+	}
+	if payload.Amount, err = readCompactSize(pr); err != nil {
+		return Payload{}, errors.New("invalid amount")
+	}
+	return payload, nil
+}
+
+// Encrypt does what devices do: it encrypts payload with key using a random nonce and
+// returns the p parameter that Decrypt takes.
+func Encrypt(key []byte, variant byte, payload Payload) ([]byte, error) {
+	plain := appendCompactSize(nil, payload.PIN)
+	plain = appendCompactSize(plain, payload.Amount)
+
+	nonce := make([]byte, 8)
+	if variant == VariantAES {
+		nonce = make([]byte, aes.BlockSize)
+	}
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	secret := hmacSum(key, "Round secret:", nonce)
+	var encrypted []byte
+	switch variant {
+	case VariantXOR:
+		encrypted = make([]byte, len(plain))
+		for i := range plain {
+			encrypted[i] = plain[i] ^ secret[i]
+		}
+	case VariantAES:
+		pad := aes.BlockSize - len(plain)%aes.BlockSize
+		plain = append(plain, bytes.Repeat([]byte{byte(pad)}, pad)...)
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, err
+		}
+		encrypted = make([]byte, len(plain))
+		cipher.NewCBCEncrypter(block, nonce).CryptBlocks(encrypted, plain)
+	default:
+		return nil, fmt.Errorf("unknown variant %d", variant)
+	}
+
+	p := []byte{variant, byte(len(nonce))}
+	p = append(p, nonce...)
+	p = append(p, byte(len(encrypted)))
+	p = append(p, encrypted...)
+	mac := hmacSum(key, "Data:", p)
+	return append(p, mac[:8]...), nil
+}
+
+func hmacSum(key []byte, prefix string, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(prefix))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func readPrefixed(r *bytes.Reader) ([]byte, error) {
+	l, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readCompactSize reads an integer encoded as in Bitcoin transactions: one byte below
+// 0xfd, or 0xfd, 0xfe or 0xff followed by 2, 4 or 8 little-endian bytes.
+func readCompactSize(r *bytes.Reader) (uint64, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	size := map[byte]int{0xfd: 2, 0xfe: 4, 0xff: 8}[first]
+	if size == 0 {
+		return uint64(first), nil
+	}
+
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b[:size]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func appendCompactSize(dst []byte, n uint64) []byte {
+	switch {
+	case n < 0xfd:
+		return append(dst, byte(n))
+	case n <= 0xffff:
+		return binary.LittleEndian.AppendUint16(append(dst, 0xfd), uint16(n))
+	case n <= 0xffffffff:
+		return binary.LittleEndian.AppendUint32(append(dst, 0xfe), uint32(n))
+	default:
+		return binary.LittleEndian.AppendUint64(append(dst, 0xff), n)
+	}
+}
@@ -0,0 +1,218 @@
+package pos
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/go-lnurl"
+	"github.com/fiatjaf/go-lnurl/lnurltest"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := []byte("device secret")
+	for _, variant := range []byte{VariantXOR, VariantAES} {
+		for _, payload := range []Payload{{1234, 250}, {0, 0}, {98765432, 1 << 40}} {
+			p, err := Encrypt(key, variant, payload)
+			if err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+			if got, err := Decrypt(key, p); err != nil || got != payload {
+				t.Errorf("Decrypt() variant %d got = %+v, %v, want %+v", variant, got, err, payload)
+			}
+
+			if _, err := Decrypt([]byte("other secret"), p); err == nil {
+				t.Errorf("Decrypt() variant %d accepted the wrong key", variant)
+			}
+			p[len(p)-10] ^= 1
+			if _, err := Decrypt(key, p); err == nil {
+				t.Errorf("Decrypt() variant %d accepted a tampered payload", variant)
+			}
+		}
+	}
+}
+
+type invoices map[string]*lnurl.InvoiceStatus
+
+func (i invoices) LookupInvoice(paymentHash string) (*lnurl.InvoiceStatus, error) {
+	return i[paymentHash], nil
+}
+
+func TestServer(t *testing.T) {
+	statuses := invoices{}
+	withPreimage := true
+	server := &Server{
+		Invoice: func(device Device, msats int64, descriptionHash []byte) (string, []byte, error) {
+			pr, preimage := lnurltest.NewInvoice(msats, descriptionHash)
+			hash := sha256.Sum256(preimage)
+			statuses[hex.EncodeToString(hash[:])] = &lnurl.InvoiceStatus{PR: pr, Preimage: preimage}
+			if !withPreimage {
+				return pr, nil, nil
+			}
+			return pr, preimage, nil
+		},
+		Convert: func(currency string, cents uint64) (int64, error) {
+			return int64(cents) * 20000, nil
+		},
+		Lookup: statuses,
+	}
+	server.Register(Device{ID: "shop", Key: []byte("shop secret"), Currency: "sat"})
+	server.Register(Device{ID: "cafe", Key: []byte("cafe secret"), Currency: "usd"})
+
+	mux := http.NewServeMux()
+	mux.Handle("/pos/", server.Handler())
+	mux.Handle("/callback", server.CallbackHandler())
+	mux.Handle("/receipt", server.ReceiptHandler())
+	s := httptest.NewTLSServer(mux)
+	defer s.Close()
+	previous := lnurl.WithCustomClient(s.Client())
+	defer lnurl.WithCustomClient(previous)
+	server.Callback = s.URL + "/callback"
+	server.ReceiptURL = s.URL + "/receipt"
+
+	link := func(device string, key string, payload Payload) string {
+		p, _ := Encrypt([]byte(key), VariantXOR, payload)
+		return s.URL + "/pos/" + device + "?p=" + base64.URLEncoding.EncodeToString(p)
+	}
+
+	_, params, err := lnurl.HandleLNURL(link("shop", "shop secret", Payload{PIN: 4321, Amount: 210}))
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	pay := params.(lnurl.LNURLPayParams)
+	if pay.MinSendable != 210000 || pay.MaxSendable != 210000 {
+		t.Errorf("got sendable = %d-%d", pay.MinSendable, pay.MaxSendable)
+	}
+	if _, err := pay.Call(100000, "", nil); err == nil {
+		t.Errorf("Call() accepted another amount")
+	}
+	values, err := pay.Call(210000, "", nil)
+	if err != nil {
+		t.Fatalf("Call() error = %v", err)
+	}
+	preimage := statuses[values.ParsedInvoice.PaymentHash].Preimage
+	if pin, err := values.SuccessAction.Decipher(preimage); err != nil || pin != "4321" {
+		t.Errorf("Decipher() got = %s, %v", pin, err)
+	}
+	if again, err := pay.Call(210000, "", nil); err != nil || again.PR != values.PR {
+		t.Errorf("Call() again got = %v, %v", again, err)
+	}
+
+	if _, _, err := lnurl.HandleLNURL(link("shop", "cafe secret", Payload{PIN: 1, Amount: 1})); err == nil {
+		t.Errorf("HandleLNURL() accepted a link made with another key")
+	}
+	if _, _, err := lnurl.HandleLNURL(link("shop", "shop secret", Payload{PIN: 1, Amount: 0})); err == nil ||
+		!strings.Contains(err.Error(), "positive") {
+		t.Errorf("HandleLNURL() accepted a link for nothing")
+	}
+	if _, _, err := lnurl.HandleLNURL(link("shop", "shop secret", Payload{PIN: 1, Amount: 1 << 62})); err == nil ||
+		!strings.Contains(err.Error(), "too big") {
+		t.Errorf("HandleLNURL() accepted a link for more msats than fit in an int64")
+	}
+	server.Lookup = nil
+	if _, _, err := lnurl.HandleLNURL(link("shop", "shop secret", Payload{PIN: 1, Amount: 1})); err == nil ||
+		!strings.Contains(err.Error(), "Lookup") {
+		t.Errorf("HandleLNURL() served a link without Lookup")
+	}
+	server.Lookup = statuses
+
+	withPreimage = false
+	_, params, err = lnurl.HandleLNURL(link("cafe", "cafe secret", Payload{PIN: 777, Amount: 350}))
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	pay = params.(lnurl.LNURLPayParams)
+	values, err = pay.Call(7000000, "", nil)
+	if err != nil || values.SuccessAction.Tag != "url" {
+		t.Fatalf("Call() got = %+v, %v", values, err)
+	}
+
+	receipt := func() string {
+		resp, err := s.Client().Get(values.SuccessAction.URL)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+	if got := receipt(); strings.Contains(got, "777") {
+		t.Errorf("receipt showed the PIN before payment: %s", got)
+	}
+	statuses[values.ParsedInvoice.PaymentHash].Settled = true
+	if got := receipt(); got != "PIN: 777\n" {
+		t.Errorf("receipt got = %s", got)
+	}
+
+	server.mu.Lock()
+	for _, pay := range server.payments {
+		pay.expires = time.Now()
+	}
+	server.mu.Unlock()
+	_, params, err = lnurl.HandleLNURL(link("shop", "shop secret", Payload{PIN: 1234, Amount: 10}))
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	if n := len(server.payments); n != 1 {
+		t.Errorf("Handler() kept %d sales", n)
+	}
+	if got := receipt(); strings.Contains(got, "777") {
+		t.Errorf("receipt of an expired sale got = %s", got)
+	}
+}
+
+func TestATM(t *testing.T) {
+	var paid []string
+	atm := &ATM{
+		Pay: func(device Device, msats int64, pr string) error {
+			paid = append(paid, pr)
+			return nil
+		},
+		Links: &MemoryLinks{},
+	}
+	atm.Register(Device{ID: "atm", Key: []byte("atm secret"), Currency: "sat"})
+
+	mux := http.NewServeMux()
+	mux.Handle("/atm/", atm.Handler())
+	mux.Handle("/callback", atm.CallbackHandler())
+	s := httptest.NewTLSServer(mux)
+	defer s.Close()
+	previous := lnurl.WithCustomClient(s.Client())
+	defer lnurl.WithCustomClient(previous)
+	atm.Callback = s.URL + "/callback"
+
+	p, _ := Encrypt([]byte("atm secret"), VariantXOR, Payload{PIN: 38291, Amount: 500})
+	link := s.URL + "/atm/atm?p=" + base64.RawURLEncoding.EncodeToString(p)
+
+	_, params, err := lnurl.HandleLNURL(link)
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	withdraw := params.(lnurl.LNURLWithdrawResponse)
+	if withdraw.MinWithdrawable != 500000 || withdraw.MaxWithdrawable != 500000 {
+		t.Errorf("got withdrawable = %d-%d", withdraw.MinWithdrawable, withdraw.MaxWithdrawable)
+	}
+
+	pr, _ := lnurltest.NewInvoice(400000, nil)
+	if err := withdraw.Call(pr); err == nil {
+		t.Errorf("Call() accepted an invoice for another amount")
+	}
+
+	// the k1 is gone after a failed call too
+	_, params, _ = lnurl.HandleLNURL(link)
+	withdraw = params.(lnurl.LNURLWithdrawResponse)
+	pr, _ = lnurltest.NewInvoice(500000, nil)
+	if err := withdraw.Call(pr); err != nil || len(paid) != 1 || paid[0] != pr {
+		t.Errorf("Call() error = %v, paid %v", err, paid)
+	}
+
+	if _, _, err := lnurl.HandleLNURL(link); err == nil || !strings.Contains(err.Error(), "already withdrawn") {
+		t.Errorf("HandleLNURL() of a withdrawn link error = %v", err)
+	}
+}
@@ -0,0 +1,331 @@
+package pos
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/go-lnurl"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+)
+
+// Device is a point of sale registered with a Server.
+type Device struct {
+	ID  string
+	Key []byte
+
+	// Currency is the currency the device charges in, "sat" for satoshis.
+	Currency string
+
+	// Description is the text/plain metadata of its payRequests.
+	Description string
+}
+
+// Server serves the links of registered devices. The links are Handler URLs ending in the
+// ID of the device, like https://service.com/pos/<id>?p=<p>, with p base64url-encoded.
+type Server struct {
+	// Callback is the URL CallbackHandler is served at.
+	Callback string
+
+	// Invoice issues an invoice for a sale of msats made by device, committing to
+	// descriptionHash. If it returns the preimage, the PIN is sent encrypted with it in an
+	// aes successAction. Otherwise the successAction is a link to ReceiptURL, where the
+	// PIN is only shown once Lookup reports the invoice settled.
+	Invoice func(device Device, msats int64, descriptionHash []byte) (pr string, preimage []byte, err error)
+
+	// Convert returns the msats worth amount in the smallest unit of currency. It is only
+	// needed for devices that don't charge in satoshis.
+	Convert func(currency string, amount uint64) (int64, error)
+
+	// ReceiptURL is the absolute https URL ReceiptHandler is served at, and Lookup is
+	// what it uses to check invoices. Both must be set.
+	ReceiptURL string
+	Lookup     lnurl.InvoiceLookup
+
+	registry
+	payments map[string]*payment
+}
+
+// PaymentTimeout is how long a Server keeps a sale after its link is first resolved, along
+// with the invoice issued for it, so the receipt can be checked for that long.
+var PaymentTimeout = time.Hour
+
+// payment is a sale decoded from a link, with the invoice issued for it if any.
+type payment struct {
+	device  Device
+	payload Payload
+	msats   int64
+	values  *lnurl.LNURLPayValues
+	expires time.Time
+}
+
+// Handler serves the links of the devices with a payRequest for the fixed amount of the
+// sale.
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.check(); err != nil {
+			respond(w, lnurl.ErrorResponse(err.Error()))
+			return
+		}
+		link, err := s.decode(r)
+		if err != nil {
+			respond(w, lnurl.ErrorResponse(err.Error()))
+			return
+		}
+		device, payload, id := link.device, link.payload, link.id
+		msats, err := toMsats(s.Convert, device, payload.Amount)
+		if err != nil {
+			respond(w, lnurl.ErrorResponse(err.Error()))
+			return
+		}
+
+		// the same link always resolves to the same sale until it expires
+		now := time.Now()
+		s.mu.Lock()
+		if s.payments == nil {
+			s.payments = make(map[string]*payment)
+		}
+		for k, pay := range s.payments {
+			if now.After(pay.expires) {
+				delete(s.payments, k)
+			}
+		}
+		if _, ok := s.payments[id]; !ok {
+			s.payments[id] = &payment{device: device, payload: payload, msats: msats,
+				expires: now.Add(PaymentTimeout)}
+		}
+		s.mu.Unlock()
+
+		params := s.params(device, msats)
+		callback, _ := url.Parse(s.Callback)
+		query := callback.Query()
+		query.Set("id", id)
+		callback.RawQuery = query.Encode()
+		params.Callback = callback.String()
+		respond(w, params)
+	})
+}
+
+// CallbackHandler serves the callback of the payRequests, issuing the invoice for a sale.
+// Calling it again for the same sale returns the same invoice.
+func (s *Server) CallbackHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.check(); err != nil {
+			respond(w, lnurl.ErrorResponse(err.Error()))
+			return
+		}
+		query := r.URL.Query()
+		s.mu.Lock()
+		pay, ok := s.payments[query.Get("id")]
+		var values *lnurl.LNURLPayValues
+		if ok {
+			values = pay.values
+		}
+		s.mu.Unlock()
+		if !ok || time.Now().After(pay.expires) {
+			respond(w, lnurl.ErrorResponse("Unknown payment"))
+			return
+		}
+
+		msats, err := strconv.ParseInt(query.Get("amount"), 10, 64)
+		if err != nil || msats != pay.msats {
+			respond(w, lnurl.ErrorResponse(fmt.Sprintf("Amount must be %d msats", pay.msats)))
+			return
+		}
+
+		if values != nil {
+			respond(w, values)
+			return
+		}
+
+		params := s.params(pay.device, pay.msats)
+		hash := sha256.Sum256([]byte(params.EncodedMetadata))
+		pr, preimage, err := s.Invoice(pay.device, pay.msats, hash[:])
+		if err != nil {
+			respond(w, lnurl.ErrorResponse(err.Error()))
+			return
+		}
+
+		values = &lnurl.LNURLPayValues{LNURLResponse: lnurl.OkResponse(), PR: pr, Routes: []interface{}{}}
+		pin := strconv.FormatUint(pay.payload.PIN, 10)
+		if preimage != nil {
+			values.SuccessAction, err = lnurl.AESAction("PIN", preimage, pin)
+			if err != nil {
+				respond(w, lnurl.ErrorResponse(err.Error()))
+				return
+			}
+		} else {
+			receipt, _ := url.Parse(s.ReceiptURL)
+			receiptQuery := receipt.Query()
+			receiptQuery.Set("id", query.Get("id"))
+			receipt.RawQuery = receiptQuery.Encode()
+			values.SuccessAction = lnurl.Action("Open to see the PIN once paid", receipt.String())
+		}
+
+		// if another request issued an invoice meanwhile, that is the one for the sale
+		s.mu.Lock()
+		if pay.values == nil {
+			pay.values = values
+		}
+		values = pay.values
+		s.mu.Unlock()
+		respond(w, values)
+	})
+}
+
+// ReceiptHandler serves the ReceiptURL links, showing the PIN of a sale once its invoice
+// is settled.
+func (s *Server) ReceiptHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		pin, err := s.pin(r.URL.Query().Get("id"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintln(w, err.Error())
+			return
+		}
+		fmt.Fprintf(w, "PIN: %d\n", pin)
+	})
+}
+
+// pin returns the PIN of the sale with the given id if its invoice is settled.
+func (s *Server) pin(id string) (uint64, error) {
+	if err := s.check(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	pay, ok := s.payments[id]
+	var values *lnurl.LNURLPayValues
+	if ok && time.Now().Before(pay.expires) {
+		values = pay.values
+	}
+	s.mu.Unlock()
+	if values == nil {
+		return 0, errors.New("unknown payment")
+	}
+
+	inv, err := decodepay.Decodepay(values.PR)
+	if err != nil {
+		return 0, err
+	}
+	status, err := s.Lookup.LookupInvoice(inv.PaymentHash)
+	if err != nil {
+		return 0, err
+	}
+	if status == nil || !status.Settled {
+		return 0, errors.New("not paid yet")
+	}
+	return pay.payload.PIN, nil
+}
+
+// check tells whether the server is configured well enough to serve sales.
+func (s *Server) check() error {
+	callback, err := url.Parse(s.Callback)
+	if err != nil || callback.Scheme != "https" || callback.Host == "" {
+		return errors.New("Callback must be an absolute https URL")
+	}
+	receipt, err := url.Parse(s.ReceiptURL)
+	if err != nil || receipt.Scheme != "https" || receipt.Host == "" {
+		return errors.New("ReceiptURL must be an absolute https URL")
+	}
+	if s.Lookup == nil {
+		return errors.New("Lookup is not set")
+	}
+	return nil
+}
+
+func (s *Server) params(device Device, msats int64) lnurl.LNURLPayParams {
+	params := lnurl.LNURLPayParams{
+		LNURLResponse: lnurl.OkResponse(),
+		Tag:           "payRequest",
+		MinSendable:   msats,
+		MaxSendable:   msats,
+	}
+	params.Metadata.Description = device.Description
+	if params.Metadata.Description == "" {
+		params.Metadata.Description = "Payment to " + device.ID
+	}
+	params.EncodedMetadata = params.MetadataEncoded()
+	return params
+}
+
+// registry holds the registered devices.
+type registry struct {
+	mu      sync.Mutex
+	devices map[string]Device
+}
+
+// Register adds a device, replacing the one with the same ID.
+func (reg *registry) Register(device Device) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.devices == nil {
+		reg.devices = make(map[string]Device)
+	}
+	reg.devices[device.ID] = device
+}
+
+// link is a link made by a device, decoded.
+type link struct {
+	device  Device
+	payload Payload
+
+	// id identifies the link, it is derived from p.
+	id string
+}
+
+// decode authenticates and decrypts the link of a registered device requested with r.
+func (reg *registry) decode(r *http.Request) (link, error) {
+	reg.mu.Lock()
+	device, ok := reg.devices[path.Base(r.URL.Path)]
+	reg.mu.Unlock()
+	if !ok {
+		return link{}, errors.New("Unknown device")
+	}
+
+	p, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(r.URL.Query().Get("p"), "="))
+	if err != nil {
+		return link{}, errors.New("Invalid p")
+	}
+	payload, err := Decrypt(device.Key, p)
+	if err != nil {
+		return link{}, err
+	}
+
+	hash := sha256.Sum256(p)
+	return link{device: device, payload: payload, id: hex.EncodeToString(hash[:16])}, nil
+}
+
+// toMsats returns the msats worth amount in the currency of device.
+func toMsats(convert func(string, uint64) (int64, error), device Device, amount uint64) (int64, error) {
+	if amount == 0 {
+		return 0, errors.New("Amount must be positive")
+	}
+	if device.Currency == "sat" {
+		if amount > math.MaxInt64/1000 {
+			return 0, errors.New("Amount is too big")
+		}
+		return int64(amount) * 1000, nil
+	}
+	if convert == nil {
+		return 0, errors.New("Can't convert from " + device.Currency)
+	}
+	return convert(device.Currency, amount)
+}
+
+func respond(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
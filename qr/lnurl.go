@@ -0,0 +1,31 @@
+package qr
+
+import "strings"
+
+// LNURL encodes a bech32-encoded lnurl. It is uppercased so it fits the alphanumeric mode,
+// and the error correction level is the highest that doesn't make the code any bigger.
+// Other lnurl formats like LUD-17 URLs are encoded as they are.
+func LNURL(lnurl string) (*Code, error) {
+	return Encode(normalize(lnurl), L)
+}
+
+// LightningURI encodes a bech32-encoded lnurl prefixed with the "lightning:" URI scheme,
+// which lets phones open a wallet directly when scanning the code.
+func LightningURI(lnurl string) (*Code, error) {
+	lnurl = normalize(lnurl)
+	if strings.HasPrefix(lnurl, "LNURL1") {
+		return Encode("LIGHTNING:"+lnurl, L)
+	}
+	return Encode("lightning:"+lnurl, L)
+}
+
+func normalize(lnurl string) string {
+	lnurl = strings.TrimSpace(lnurl)
+	if strings.HasPrefix(strings.ToLower(lnurl), "lightning:") {
+		lnurl = lnurl[len("lightning:"):]
+	}
+	if strings.HasPrefix(strings.ToLower(lnurl), "lnurl1") {
+		return strings.ToUpper(lnurl)
+	}
+	return lnurl
+}
@@ -0,0 +1,587 @@
+// Package qr is a small QR code encoder tailored for lnurl strings.
+//
+// It implements the QR Code Model 2 specification (ISO/IEC 18004) for versions 1 to 40
+// with numeric, alphanumeric and byte modes, and renders codes as PNG, SVG or text for
+// terminals.
+package qr
+
+import (
+	"errors"
+	"strings"
+)
+
+// Level is a QR code error correction level.
+type Level int
+
+const (
+	L Level = iota // recovers ~7% of the symbol
+	M              // recovers ~15% of the symbol
+	Q              // recovers ~25% of the symbol
+	H              // recovers ~30% of the symbol
+)
+
+func (l Level) String() string {
+	return [...]string{"L", "M", "Q", "H"}[l]
+}
+
+// formatBits are the two bits that identify the level in the format information.
+func (l Level) formatBits() int {
+	return [...]int{1, 0, 3, 2}[l]
+}
+
+type mode int
+
+const (
+	numericMode      mode = 0x1
+	alphanumericMode mode = 0x2
+	byteMode         mode = 0x4
+)
+
+const alphanumericCharset = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// Code is an encoded QR code symbol. Modules are addressed by column x and row y, starting
+// at the top left corner and not including the quiet zone.
+type Code struct {
+	Version int
+	Level   Level
+	Mask    int
+	Size    int
+
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// Black tells whether the module at column x and row y is dark. Coordinates outside the
+// symbol are always light.
+func (c *Code) Black(x, y int) bool {
+	return x >= 0 && x < c.Size && y >= 0 && y < c.Size && c.modules[y][x]
+}
+
+// Encode encodes text using the most compact mode that can represent it and the smallest
+// version that fits at the given error correction level. The level is then raised as far
+// as possible without increasing the version.
+func Encode(text string, level Level) (*Code, error) {
+	m := pickMode(text)
+	data := encodeSegment(m, text)
+
+	version := 0
+	for v := 1; v <= 40; v++ {
+		if segmentBits(m, len(text), len(data.bits), v) <= numDataCodewords(v, level)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errors.New("text too long to fit in a QR code")
+	}
+
+	used := segmentBits(m, len(text), len(data.bits), version)
+	for _, higher := range []Level{M, Q, H} {
+		if higher > level && used <= numDataCodewords(version, higher)*8 {
+			level = higher
+		}
+	}
+
+	// mode indicator, character count and payload
+	var bb bitBuffer
+	bb.append(int(m), 4)
+	bb.append(len(text), charCountBits(m, version))
+	bb.bits = append(bb.bits, data.bits...)
+
+	// terminator and padding up to the data capacity
+	capacity := numDataCodewords(version, level) * 8
+	bb.append(0, min(4, capacity-len(bb.bits)))
+	bb.append(0, (8-len(bb.bits)%8)%8)
+	for pad := 0xEC; len(bb.bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	codewords := make([]byte, len(bb.bits)/8)
+	for i, bit := range bb.bits {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+
+	code := newCode(version, level)
+	code.drawCodewords(code.addECCAndInterleave(codewords))
+
+	// pick the mask with the lowest penalty
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormatBits(mask)
+		if penalty := code.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		code.applyMask(mask) // undo
+	}
+	code.Mask = best
+	code.applyMask(best)
+	code.drawFormatBits(best)
+
+	return code, nil
+}
+
+func pickMode(text string) mode {
+	numeric, alphanumeric := true, true
+	for i := 0; i < len(text); i++ {
+		if text[i] < '0' || text[i] > '9' {
+			numeric = false
+		}
+		if strings.IndexByte(alphanumericCharset, text[i]) == -1 {
+			alphanumeric = false
+		}
+	}
+
+	switch {
+	case numeric:
+		return numericMode
+	case alphanumeric:
+		return alphanumericMode
+	default:
+		return byteMode
+	}
+}
+
+func charCountBits(m mode, version int) int {
+	i := 0
+	if version >= 27 {
+		i = 2
+	} else if version >= 10 {
+		i = 1
+	}
+
+	switch m {
+	case numericMode:
+		return [...]int{10, 12, 14}[i]
+	case alphanumericMode:
+		return [...]int{9, 11, 13}[i]
+	default:
+		return [...]int{8, 16, 16}[i]
+	}
+}
+
+// segmentBits is the total number of bits used by a segment in the given version, or a
+// number too big to fit anywhere if the character count overflows its field.
+func segmentBits(m mode, chars int, payloadBits int, version int) int {
+	ccbits := charCountBits(m, version)
+	if chars >= 1<<ccbits {
+		return 1 << 30
+	}
+	return 4 + ccbits + payloadBits
+}
+
+func encodeSegment(m mode, text string) (bb bitBuffer) {
+	switch m {
+	case numericMode:
+		for i := 0; i < len(text); i += 3 {
+			n := min(3, len(text)-i)
+			value := 0
+			for _, c := range text[i : i+n] {
+				value = value*10 + int(c-'0')
+			}
+			bb.append(value, n*3+1)
+		}
+	case alphanumericMode:
+		for i := 0; i < len(text); i += 2 {
+			value := strings.IndexByte(alphanumericCharset, text[i])
+			if i+1 < len(text) {
+				value = value*45 + strings.IndexByte(alphanumericCharset, text[i+1])
+				bb.append(value, 11)
+			} else {
+				bb.append(value, 6)
+			}
+		}
+	default:
+		for i := 0; i < len(text); i++ {
+			bb.append(int(text[i]), 8)
+		}
+	}
+	return bb
+}
+
+type bitBuffer struct {
+	bits []bool
+}
+
+func (bb *bitBuffer) append(value int, length int) {
+	for i := length - 1; i >= 0; i-- {
+		bb.bits = append(bb.bits, (value>>i)&1 == 1)
+	}
+}
+
+func newCode(version int, level Level) *Code {
+	size := version*4 + 17
+	code := &Code{
+		Version:    version,
+		Level:      level,
+		Size:       size,
+		modules:    make([][]bool, size),
+		isFunction: make([][]bool, size),
+	}
+	for i := range code.modules {
+		code.modules[i] = make([]bool, size)
+		code.isFunction[i] = make([]bool, size)
+	}
+	code.drawFunctionPatterns()
+	return code
+}
+
+func (c *Code) setFunctionModule(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunction[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	// timing patterns
+	for i := 0; i < c.Size; i++ {
+		c.setFunctionModule(6, i, i%2 == 0)
+		c.setFunctionModule(i, 6, i%2 == 0)
+	}
+
+	// finder patterns and their separators
+	for _, center := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x >= 0 && x < c.Size && y >= 0 && y < c.Size {
+					dist := max(abs(dx), abs(dy))
+					c.setFunctionModule(x, y, dist != 2 && dist != 4)
+				}
+			}
+		}
+	}
+
+	// alignment patterns, except where they would overlap the finders
+	positions := alignmentPatternPositions(c.Version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.setFunctionModule(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// reserve the format areas, they are overwritten once the mask is known
+	c.drawFormatBits(0)
+
+	// version information
+	if c.Version >= 7 {
+		rem := c.Version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := c.Version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 == 1
+			a, b := c.Size-11+i%3, i/3
+			c.setFunctionModule(a, b, dark)
+			c.setFunctionModule(b, a, dark)
+		}
+	}
+}
+
+func (c *Code) drawFormatBits(mask int) {
+	bits := formatInformation(c.Level, mask)
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	// first copy, around the top left finder
+	for i := 0; i <= 5; i++ {
+		c.setFunctionModule(8, i, bit(i))
+	}
+	c.setFunctionModule(8, 7, bit(6))
+	c.setFunctionModule(8, 8, bit(7))
+	c.setFunctionModule(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunctionModule(14-i, 8, bit(i))
+	}
+
+	// second copy, split between the other two finders
+	for i := 0; i < 8; i++ {
+		c.setFunctionModule(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunctionModule(8, c.Size-15+i, bit(i))
+	}
+	c.setFunctionModule(8, c.Size-8, true) // the dark module
+}
+
+func formatInformation(level Level, mask int) int {
+	data := level.formatBits()<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// addECCAndInterleave splits the data codewords in blocks, appends the Reed-Solomon
+// error correction codewords to each and interleaves them all.
+func (c *Code) addECCAndInterleave(data []byte) []byte {
+	numBlocks := numErrorCorrectionBlocks[c.Level][c.Version]
+	eccLen := eccCodewordsPerBlock[c.Level][c.Version]
+	rawCodewords := numRawDataModules(c.Version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := range blocks {
+		datLen := shortBlockLen - eccLen
+		if i >= numShortBlocks {
+			datLen++
+		}
+		dat := data[k : k+datLen]
+		k += datLen
+
+		block := make([]byte, 0, shortBlockLen+1)
+		block = append(block, dat...)
+		if i < numShortBlocks {
+			block = append(block, 0) // placeholder, skipped when interleaving
+		}
+		blocks[i] = append(block, reedSolomonRemainder(dat, divisor)...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortBlockLen-eccLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if upward {
+					y = c.Size - 1 - vert
+				}
+				if !c.isFunction[y][x] && i < len(data)*8 {
+					c.modules[y][x] = (data[i>>3]>>(7-i&7))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask XORs the data modules with the given mask pattern; applying it twice undoes it.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.isFunction[y][x] && maskBit(mask, x, y) {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+func maskBit(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// penalty scores the symbol according to the four mask evaluation rules of the spec.
+func (c *Code) penalty() int {
+	result := 0
+	line := make([]bool, c.Size)
+
+	for _, vertical := range []bool{false, true} {
+		for a := 0; a < c.Size; a++ {
+			for b := 0; b < c.Size; b++ {
+				if vertical {
+					line[b] = c.modules[b][a]
+				} else {
+					line[b] = c.modules[a][b]
+				}
+			}
+
+			// runs of five or more modules of the same color
+			run := 1
+			for b := 1; b <= c.Size; b++ {
+				if b < c.Size && line[b] == line[b-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += 3 + run - 5
+				}
+				run = 1
+			}
+
+			// finder-like 1:1:3:1:1 patterns with four light modules on either side
+			for b := 0; b+7 <= c.Size; b++ {
+				if !line[b] || line[b+1] || !line[b+2] || !line[b+3] || !line[b+4] || line[b+5] || !line[b+6] {
+					continue
+				}
+				if lightRun(line, b-4, b) || lightRun(line, b+7, b+11) {
+					result += 40
+				}
+			}
+		}
+	}
+
+	// 2x2 blocks of the same color
+	for y := 0; y < c.Size-1; y++ {
+		for x := 0; x < c.Size-1; x++ {
+			color := c.modules[y][x]
+			if color == c.modules[y][x+1] && color == c.modules[y+1][x] && color == c.modules[y+1][x+1] {
+				result += 3
+			}
+		}
+	}
+
+	// balance of dark and light modules
+	dark := 0
+	for _, row := range c.modules {
+		for _, module := range row {
+			if module {
+				dark++
+			}
+		}
+	}
+	total := c.Size * c.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	result += k * 10
+
+	return result
+}
+
+// lightRun tells whether all modules in line[from:to] are light, counting the area outside
+// the symbol as light.
+func lightRun(line []bool, from, to int) bool {
+	for i := from; i < to; i++ {
+		if i >= 0 && i < len(line) && line[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func alignmentPatternPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*8 + numAlign*3 + 5) / (numAlign*4 - 4) * 2
+	result := make([]int, numAlign)
+	result[0] = 6
+	for i, pos := numAlign-1, version*4+17-7; i >= 1; i, pos = i-1, pos-step {
+		result[i] = pos
+	}
+	return result
+}
+
+// numRawDataModules is the number of modules available for data and error correction
+// codewords in a version, including remainder bits.
+func numRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		result -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 -
+		eccCodewordsPerBlock[level][version]*numErrorCorrectionBlocks[level][version]
+}
+
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func reedSolomonRemainder(data []byte, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies two elements of GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+var eccCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var numErrorCorrectionBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
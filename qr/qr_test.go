@@ -0,0 +1,259 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// decode reads back the text from a symbol, checking the format information, the error
+// correction codewords and the segment structure along the way.
+func decode(modules [][]bool) (string, error) {
+	size := len(modules)
+	version := (size - 17) / 4
+	if version < 1 || version > 40 || version*4+17 != size {
+		return "", errors.New("invalid size")
+	}
+
+	// format information from the first copy
+	bits := 0
+	read := func(x, y, i int) {
+		if modules[y][x] {
+			bits |= 1 << i
+		}
+	}
+	for i := 0; i <= 5; i++ {
+		read(8, i, i)
+	}
+	read(8, 7, 6)
+	read(8, 8, 7)
+	read(7, 8, 8)
+	for i := 9; i < 15; i++ {
+		read(14-i, 8, i)
+	}
+	var level Level
+	mask := -1
+	for l := L; l <= H; l++ {
+		for m := 0; m < 8; m++ {
+			if formatInformation(l, m) == bits {
+				level, mask = l, m
+			}
+		}
+	}
+	if mask == -1 {
+		return "", errors.New("invalid format information")
+	}
+
+	// unmask and read the codewords in the same order they were drawn
+	template := newCode(version, level)
+	raw := make([]byte, numRawDataModules(version)/8)
+	i := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if upward {
+					y = size - 1 - vert
+				}
+				if template.isFunction[y][x] || i >= len(raw)*8 {
+					continue
+				}
+				if modules[y][x] != maskBit(mask, x, y) {
+					raw[i>>3] |= 1 << (7 - i&7)
+				}
+				i++
+			}
+		}
+	}
+
+	// deinterleave and check the error correction codewords of each block
+	numBlocks := numErrorCorrectionBlocks[level][version]
+	eccLen := eccCodewordsPerBlock[level][version]
+	numShortBlocks := numBlocks - len(raw)%numBlocks
+	shortBlockLen := len(raw) / numBlocks
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i <= shortBlockLen; i++ {
+		for j := range blocks {
+			if i != shortBlockLen-eccLen || j >= numShortBlocks {
+				blocks[j] = append(blocks[j], raw[k])
+				k++
+			}
+		}
+	}
+	var data []byte
+	for _, block := range blocks {
+		dat, ecc := block[:len(block)-eccLen], block[len(block)-eccLen:]
+		if !bytes.Equal(reedSolomonRemainder(dat, reedSolomonDivisor(eccLen)), ecc) {
+			return "", errors.New("error correction codewords don't match")
+		}
+		data = append(data, dat...)
+	}
+
+	// segments
+	pos := 0
+	next := func(n int) int {
+		v := 0
+		for ; n > 0; n-- {
+			v = v<<1 | int(data[pos>>3]>>(7-pos&7)&1)
+			pos++
+		}
+		return v
+	}
+	var text strings.Builder
+	for pos+4 <= len(data)*8 {
+		m := mode(next(4))
+		if m == 0 {
+			break
+		}
+		count := next(charCountBits(m, version))
+		switch m {
+		case numericMode:
+			for count > 0 {
+				n := min(3, count)
+				text.WriteString(fmt.Sprintf("%0*d", n, next(n*3+1)))
+				count -= n
+			}
+		case alphanumericMode:
+			for ; count >= 2; count -= 2 {
+				v := next(11)
+				text.WriteByte(alphanumericCharset[v/45])
+				text.WriteByte(alphanumericCharset[v%45])
+			}
+			if count > 0 {
+				text.WriteByte(alphanumericCharset[next(6)])
+			}
+		case byteMode:
+			for ; count > 0; count-- {
+				text.WriteByte(byte(next(8)))
+			}
+		default:
+			return "", errors.New("unsupported mode")
+		}
+	}
+
+	return text.String(), nil
+}
+
+func TestDataCapacities(t *testing.T) {
+	// data codewords from the capacity tables of the spec
+	tests := []struct {
+		version int
+		want    [4]int
+	}{
+		{1, [4]int{19, 16, 13, 9}},
+		{10, [4]int{274, 216, 154, 122}},
+		{20, [4]int{861, 669, 485, 385}},
+		{30, [4]int{1735, 1373, 985, 745}},
+		{40, [4]int{2956, 2334, 1666, 1276}},
+	}
+	for _, tt := range tests {
+		for l := L; l <= H; l++ {
+			if got := numDataCodewords(tt.version, l); got != tt.want[l] {
+				t.Errorf("numDataCodewords(%d, %s) got = %d, want %d", tt.version, l, got, tt.want[l])
+			}
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		desc  string
+		text  string
+		mode  mode
+		level Level
+	}{
+		{desc: "NUMERIC", text: "0123456789012", mode: numericMode, level: M},
+		{desc: "ALPHANUMERIC", text: "LNURL1DP68GURN8GHJ7CTSDYHXV6TPW34XZE3WVDHK6TMKXGHKCMN4WFKZ7URP0Y0Q3PEG", mode: alphanumericMode, level: L},
+		{desc: "BYTE", text: "lnurlp://api.fiatjaf.com/v2/lnurl/pay?a=1", mode: byteMode, level: Q},
+		{desc: "LARGE_VERSION", text: strings.Repeat("LNURL1DP68GURN8GHJ7", 60), mode: alphanumericMode, level: M},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if m := pickMode(tt.text); m != tt.mode {
+				t.Errorf("pickMode() got = %v, want %v", m, tt.mode)
+			}
+			code, err := Encode(tt.text, tt.level)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if code.Level < tt.level {
+				t.Errorf("Encode() lowered the level to %s", code.Level)
+			}
+			got, err := decode(code.modules)
+			if err != nil {
+				t.Fatalf("decode() error = %v", err)
+			}
+			if got != tt.text {
+				t.Errorf("decode() got = %v, want %v", got, tt.text)
+			}
+		})
+	}
+
+	if _, err := Encode(strings.Repeat("x", 3000), L); err == nil {
+		t.Errorf("Encode() accepted text too long for any version")
+	}
+}
+
+func TestLNURL(t *testing.T) {
+	lnurl := "lnurl1dp68gurn8ghj7ctsdyhxv6tpw34xze3wvdhk6tmkxghkcmn4wfkz7urp0y0q3peg"
+
+	code, err := LNURL(lnurl)
+	if err != nil {
+		t.Fatalf("LNURL() error = %v", err)
+	}
+	if got, _ := decode(code.modules); got != strings.ToUpper(lnurl) {
+		t.Errorf("LNURL() decoded = %v", got)
+	}
+	// 72 alphanumeric characters only fit version 3 at level L (77 max, 61 for M)
+	if code.Version != 3 || code.Level != L {
+		t.Errorf("LNURL() got version %d level %s, want 3 L", code.Version, code.Level)
+	}
+	// while 90 fit version 4, where the level can be raised to M (114 max, 90 for M)
+	if code, _ := Encode(strings.Repeat("A", 90), L); code.Version != 4 || code.Level != M {
+		t.Errorf("Encode() got version %d level %s, want 4 M", code.Version, code.Level)
+	}
+
+	uri, err := LightningURI(lnurl)
+	if err != nil {
+		t.Fatalf("LightningURI() error = %v", err)
+	}
+	if got, _ := decode(uri.modules); got != "LIGHTNING:"+strings.ToUpper(lnurl) {
+		t.Errorf("LightningURI() decoded = %v", got)
+	}
+
+	// PNG output must carry the same modules
+	b, err := uri.PNG(3)
+	if err != nil {
+		t.Fatalf("PNG() error = %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("png.Decode() error = %v", err)
+	}
+	modules := make([][]bool, uri.Size)
+	for y := range modules {
+		modules[y] = make([]bool, uri.Size)
+		for x := range modules[y] {
+			r, _, _, _ := img.At((x+QuietZone)*3+1, (y+QuietZone)*3+1).RGBA()
+			modules[y][x] = r < 0x8000
+		}
+	}
+	if got, err := decode(modules); err != nil || got != "LIGHTNING:"+strings.ToUpper(lnurl) {
+		t.Errorf("PNG() decoded = %v, %v", got, err)
+	}
+
+	if svg := uri.SVG(); !strings.HasPrefix(svg, "<svg") || strings.Count(svg, "h1v1h-1z") == 0 {
+		t.Errorf("SVG() got = %v", svg)
+	}
+	if lines := strings.Count(uri.Terminal(), "\n"); lines != (uri.Size+QuietZone*2+1)/2 {
+		t.Errorf("Terminal() got %d lines", lines)
+	}
+}
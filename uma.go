@@ -0,0 +1,365 @@
+package lnurl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+)
+
+// UMAVersion is the version of the Universal Money Address protocol implemented here.
+const UMAVersion = "1.0"
+
+// The KYC statuses of UMA users.
+const (
+	KYCUnknown     = "UNKNOWN"
+	KYCNotVerified = "NOT_VERIFIED"
+	KYCPending     = "PENDING"
+	KYCVerified    = "VERIFIED"
+)
+
+// UMAMaxSignatureAge is how old the timestamp of a signature can be for it to be valid.
+var UMAMaxSignatureAge = 10 * time.Minute
+
+// UMAReceiverCompliance is the compliance data of the receiving VASP in a payRequest.
+type UMAReceiverCompliance struct {
+	KYCStatus             string `json:"kycStatus"`
+	IsSubjectToTravelRule bool   `json:"isSubjectToTravelRule"`
+	ReceiverIdentifier    string `json:"receiverIdentifier"`
+	Signature             string `json:"signature"`
+	SignatureNonce        string `json:"signatureNonce"`
+	SignatureTimestamp    int64  `json:"signatureTimestamp"`
+}
+
+// UMAPayerCompliance is the compliance data of the sending VASP in the payerdata.
+// EncryptedTravelRuleInfo is passed along as given, encrypting it is up to the VASP.
+type UMAPayerCompliance struct {
+	UTXOs                   []string `json:"utxos"`
+	NodePubKey              string   `json:"nodePubKey,omitempty"`
+	KYCStatus               string   `json:"kycStatus"`
+	EncryptedTravelRuleInfo string   `json:"encryptedTravelRuleInfo,omitempty"`
+	TravelRuleFormat        string   `json:"travelRuleFormat,omitempty"`
+	UTXOCallback            string   `json:"utxoCallback,omitempty"`
+	Signature               string   `json:"signature"`
+	SignatureNonce          string   `json:"signatureNonce"`
+	SignatureTimestamp      int64    `json:"signatureTimestamp"`
+}
+
+// PayeeData is what an UMA service says about the receiver along with the invoice.
+type PayeeData struct {
+	Identifier string              `json:"identifier,omitempty"`
+	Compliance *UMAPayeeCompliance `json:"compliance,omitempty"`
+}
+
+// UMAPayeeCompliance is the compliance data of the receiving VASP in the pay response.
+type UMAPayeeCompliance struct {
+	UTXOs              []string `json:"utxos"`
+	NodePubKey         string   `json:"nodePubKey,omitempty"`
+	UTXOCallback       string   `json:"utxoCallback,omitempty"`
+	Signature          string   `json:"signature"`
+	SignatureNonce     string   `json:"signatureNonce"`
+	SignatureTimestamp int64    `json:"signatureTimestamp"`
+}
+
+// UMAPubKeys are the public keys a VASP serves at /.well-known/lnurlpubkey.
+type UMAPubKeys struct {
+	SigningPubKey    string `json:"signingPubKey"`
+	EncryptionPubKey string `json:"encryptionPubKey"`
+}
+
+// FetchUMAPubKeys gets the public keys of the VASP at domain.
+func FetchUMAPubKeys(domain string) (*UMAPubKeys, error) {
+	endpoint := "https://" + domain + "/.well-known/lnurlpubkey"
+	resp, err := actualClient.Get(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("http error calling '%s': %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	var keys UMAPubKeys
+	b, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("got invalid JSON from '%s': %w (%s)", endpoint, err, string(b))
+	}
+	return &keys, nil
+}
+
+// UMAPubKeysHandler serves keys at /.well-known/lnurlpubkey.
+func UMAPubKeysHandler(keys UMAPubKeys) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(keys)
+	})
+}
+
+// UMAVASP is a VASP sending and receiving payments to and from Universal Money Addresses
+// like $alice@vasp.com, which are lightning addresses where every step is signed by the
+// VASPs on each end with secp256k1 keys, with compliance data attached and amounts in
+// other currencies.
+type UMAVASP struct {
+	// Domain is where the VASP serves its addresses and its keys, with UMAPubKeysHandler.
+	Domain string
+	Key    *btcec.PrivateKey
+
+	// NodePubKey is the public key of the node of the VASP, sent in compliance data.
+	NodePubKey string
+
+	// PubKeys returns the keys of other VASPs. FetchUMAPubKeys is used if nil, so it's
+	// worth setting it to cache them.
+	PubKeys func(domain string) (*UMAPubKeys, error)
+
+	// MaxFee is the most, in msats, Pay accepts receiving VASPs to charge on top of the
+	// conversion.
+	MaxFee int64
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// OwnPubKeys returns the keys to be served by UMAPubKeysHandler.
+func (v *UMAVASP) OwnPubKeys() UMAPubKeys {
+	pubkey := hex.EncodeToString(v.Key.PubKey().SerializeCompressed())
+	return UMAPubKeys{SigningPubKey: pubkey, EncryptionPubKey: pubkey}
+}
+
+// FetchPayParams gets the payRequest of an UMA address with a request signed by the VASP,
+// and checks it was signed by the receiving VASP.
+func (v *UMAVASP) FetchPayParams(address string, isSubjectToTravelRule bool) (*LNURLPayParams, error) {
+	_, domain, ok := ParseInternetIdentifier(address)
+	if !ok {
+		return nil, errors.New("invalid UMA address " + address)
+	}
+	rawurl, err := lnurlURL(strings.TrimPrefix(address, "$"))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, timestamp, signature := v.sign(address)
+	endpoint, _ := url.Parse(rawurl)
+	qs := endpoint.Query()
+	qs.Set("signature", signature)
+	qs.Set("vaspDomain", v.Domain)
+	qs.Set("nonce", nonce)
+	qs.Set("timestamp", strconv.FormatInt(timestamp, 10))
+	qs.Set("isSubjectToTravelRule", strconv.FormatBool(isSubjectToTravelRule))
+	qs.Set("umaVersion", UMAVersion)
+	endpoint.RawQuery = qs.Encode()
+
+	_, params, err := HandleLNURL(endpoint.String())
+	if err != nil {
+		return nil, err
+	}
+	pay, ok := params.(LNURLPayParams)
+	if !ok {
+		return nil, errors.New("UMA address doesn't point to a payRequest")
+	}
+
+	compliance := pay.Compliance
+	if pay.UMAVersion == "" || compliance == nil {
+		return nil, errors.New("service doesn't support UMA")
+	}
+	if compliance.ReceiverIdentifier != address {
+		return nil, fmt.Errorf("compliance data is for '%s', not '%s'", compliance.ReceiverIdentifier, address)
+	}
+	if err := v.verify(domain, compliance.Signature, compliance.SignatureNonce, compliance.SignatureTimestamp,
+		address); err != nil {
+		return nil, fmt.Errorf("invalid receiver signature: %w", err)
+	}
+	return &pay, nil
+}
+
+// Pay calls the callback of params, obtained with FetchPayParams, with CallCurrency for
+// amount in the smallest unit of currency, with payer, whose identifier must be the UMA
+// address of the sender, and signed compliance data. The receiving VASP can charge at most
+// MaxFee, and the payee data is checked against its signature.
+func (v *UMAVASP) Pay(params LNURLPayParams, amount int64, currency string, payer PayerDataValues, kycStatus string, utxos []string) (*LNURLPayValues, error) {
+	if params.Compliance == nil {
+		return nil, errors.New("service doesn't support UMA")
+	}
+	receiver := params.Compliance.ReceiverIdentifier
+	_, domain, _ := ParseInternetIdentifier(receiver)
+
+	if _, _, ok := ParseInternetIdentifier(payer.LightningAddress); !ok {
+		return nil, errors.New("payer identifier must be the UMA address of the sender")
+	}
+	nonce, timestamp, signature := v.sign(payer.LightningAddress)
+	payer.Compliance = &UMAPayerCompliance{
+		UTXOs:              utxos,
+		NodePubKey:         v.NodePubKey,
+		KYCStatus:          kycStatus,
+		Signature:          signature,
+		SignatureNonce:     nonce,
+		SignatureTimestamp: timestamp,
+	}
+
+	values, err := params.CallCurrency(amount, currency, v.MaxFee, "", &payer)
+	if err != nil {
+		return nil, err
+	}
+	if values.Converted == nil {
+		return nil, errors.New("service didn't report the conversion")
+	}
+
+	if values.PayeeData == nil || values.PayeeData.Compliance == nil {
+		return nil, errors.New("service didn't return payee compliance data")
+	}
+	compliance := values.PayeeData.Compliance
+	if err := v.verify(domain, compliance.Signature, compliance.SignatureNonce, compliance.SignatureTimestamp,
+		payer.LightningAddress, receiver); err != nil {
+		return nil, fmt.Errorf("invalid payee signature: %w", err)
+	}
+	return values, nil
+}
+
+// IsUMARequest tells if the query of a request for a payRequest comes from an UMA VASP.
+func IsUMARequest(query url.Values) bool {
+	return query.Get("umaVersion") != "" && query.Get("signature") != "" && query.Get("vaspDomain") != ""
+}
+
+// VerifyPayRequest checks the signature of the VASP that asked for the payRequest of
+// address with query.
+func (v *UMAVASP) VerifyPayRequest(address string, query url.Values) error {
+	if !IsUMARequest(query) {
+		return errors.New("not an UMA request")
+	}
+	if major := strings.Split(query.Get("umaVersion"), ".")[0]; major != strings.Split(UMAVersion, ".")[0] {
+		return fmt.Errorf("unsupported UMA version %s", query.Get("umaVersion"))
+	}
+	timestamp, _ := strconv.ParseInt(query.Get("timestamp"), 10, 64)
+	return v.verify(query.Get("vaspDomain"), query.Get("signature"), query.Get("nonce"), timestamp, address)
+}
+
+// Enable turns params into the payRequest of the UMA address of a user with the given KYC
+// status. The payerdata must include the identifier and compliance data. The currencies
+// the user can receive are set with CurrencyServer.Advertise.
+func (v *UMAVASP) Enable(params *LNURLPayParams, address string, kycStatus string) {
+	params.UMAVersion = UMAVersion
+	if params.PayerData == nil {
+		params.PayerData = &PayerDataSpec{}
+	}
+	params.PayerData.LightningAddress = &PayerDataItemSpec{Mandatory: true}
+	params.PayerData.Compliance = &PayerDataItemSpec{Mandatory: true}
+
+	nonce, timestamp, signature := v.sign(address)
+	params.Compliance = &UMAReceiverCompliance{
+		KYCStatus:             kycStatus,
+		IsSubjectToTravelRule: true,
+		ReceiverIdentifier:    address,
+		Signature:             signature,
+		SignatureNonce:        nonce,
+		SignatureTimestamp:    timestamp,
+	}
+}
+
+// VerifyPayerData checks the compliance data in the payerdata sent to the callback was
+// signed by the VASP of the sender.
+func (v *UMAVASP) VerifyPayerData(payerdata *PayerDataValues) error {
+	if payerdata == nil || payerdata.Compliance == nil {
+		return errors.New("missing compliance data")
+	}
+	_, domain, ok := ParseInternetIdentifier(payerdata.LightningAddress)
+	if !ok {
+		return errors.New("missing payer identifier")
+	}
+	compliance := payerdata.Compliance
+	return v.verify(domain, compliance.Signature, compliance.SignatureNonce, compliance.SignatureTimestamp,
+		payerdata.LightningAddress)
+}
+
+// PayeeData returns the signed payee data for a payment from payer to address.
+func (v *UMAVASP) PayeeData(payer PayerDataValues, address string, utxos []string) *PayeeData {
+	nonce, timestamp, signature := v.sign(payer.LightningAddress, address)
+	return &PayeeData{
+		Identifier: address,
+		Compliance: &UMAPayeeCompliance{
+			UTXOs:              utxos,
+			NodePubKey:         v.NodePubKey,
+			Signature:          signature,
+			SignatureNonce:     nonce,
+			SignatureTimestamp: timestamp,
+		},
+	}
+}
+
+// sign signs the parts joined with "|", followed by a random nonce and the current time.
+func (v *UMAVASP) sign(parts ...string) (nonce string, timestamp int64, signature string) {
+	nonce = RandomK1()[:32]
+	timestamp = time.Now().Unix()
+	hash := umaHash(parts, nonce, timestamp)
+	return nonce, timestamp, hex.EncodeToString(ecdsa.Sign(v.Key, hash[:]).Serialize())
+}
+
+// verify checks a signature made with sign by the VASP at domain. Each nonce is only
+// accepted once.
+func (v *UMAVASP) verify(domain string, signature string, nonce string, timestamp int64, parts ...string) error {
+	signedAt := time.Unix(timestamp, 0)
+	if age := time.Since(signedAt); age > UMAMaxSignatureAge || age < -UMAMaxSignatureAge {
+		return errors.New("signature timestamp is too far from now")
+	}
+	if nonce == "" {
+		return errors.New("missing nonce")
+	}
+
+	fetch := v.PubKeys
+	if fetch == nil {
+		fetch = FetchUMAPubKeys
+	}
+	keys, err := fetch(domain)
+	if err != nil {
+		return fmt.Errorf("failed to get the keys of %s: %w", domain, err)
+	}
+	bpubkey, err := hex.DecodeString(keys.SigningPubKey)
+	if err != nil {
+		return errors.New("signing pubkey is not valid hex")
+	}
+	pubkey, err := btcec.ParsePubKey(bpubkey)
+	if err != nil {
+		return errors.New("failed to parse signing pubkey: " + err.Error())
+	}
+	bsig, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("signature is not valid hex")
+	}
+	sig, err := ecdsa.ParseDERSignature(bsig)
+	if err != nil {
+		return errors.New("failed to parse signature: " + err.Error())
+	}
+	hash := umaHash(parts, nonce, timestamp)
+	if !sig.Verify(hash[:], pubkey) {
+		return errors.New("invalid signature")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := time.Now()
+	for seen, expires := range v.nonces {
+		if now.After(expires) {
+			delete(v.nonces, seen)
+		}
+	}
+	key := domain + "|" + nonce
+	if _, seen := v.nonces[key]; seen {
+		return errors.New("nonce was already used")
+	}
+	if v.nonces == nil {
+		v.nonces = make(map[string]time.Time)
+	}
+	v.nonces[key] = signedAt.Add(2 * UMAMaxSignatureAge)
+	return nil
+}
+
+func umaHash(parts []string, nonce string, timestamp int64) [32]byte {
+	payload := strings.Join(append(parts, nonce, strconv.FormatInt(timestamp, 10)), "|")
+	return sha256.Sum256([]byte(payload))
+}
@@ -0,0 +1,147 @@
+package lnurl
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+type fixedRates map[string]float64
+
+func (r fixedRates) Rate(currency string) (float64, error) {
+	rate, ok := r[currency]
+	if !ok {
+		return 0, fmt.Errorf("no rate for %s", currency)
+	}
+	return rate, nil
+}
+
+// standInVASP is a VASP serving the UMA address of one user, $<name>@<host>, and keeping
+// the payerdata it receives.
+type standInVASP struct {
+	*UMAVASP
+	*httptest.Server
+	address    string
+	currencies *CurrencyServer
+	received   []PayerDataValues
+}
+
+func newStandInVASP(t *testing.T, name string) *standInVASP {
+	key, _ := btcec.NewPrivateKey()
+	v := &standInVASP{
+		UMAVASP: &UMAVASP{Key: key},
+		currencies: &CurrencyServer{
+			Currencies: []Currency{{Code: "USD", Name: "US Dollar", Symbol: "$", Decimals: 2}},
+			Rates:      fixedRates{"USD": 20000},
+			Fee:        1000,
+		},
+	}
+	v.Server = newTestServer(t, http.HandlerFunc(v.handle))
+	v.Domain = strings.TrimPrefix(v.URL, "https://")
+	v.address = "$" + name + "@" + v.Domain
+	return v
+}
+
+func (v *standInVASP) params() LNURLPayParams {
+	params := LNURLPayParams{
+		Tag:             "payRequest",
+		Callback:        v.URL + "/callback",
+		MinSendable:     1000,
+		MaxSendable:     100000000,
+		EncodedMetadata: `[["text/plain","payment to ` + v.address + `"]]`,
+	}
+	v.currencies.Advertise(&params)
+	return params
+}
+
+func (v *standInVASP) handle(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	switch r.URL.Path {
+	case "/.well-known/lnurlpubkey":
+		UMAPubKeysHandler(v.OwnPubKeys()).ServeHTTP(w, r)
+	case "/callback":
+		params := v.params()
+		msats, converted, err := v.currencies.Convert(query.Get("amount"), params)
+		if err != nil {
+			json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+			return
+		}
+		var payerdata PayerDataValues
+		json.Unmarshal([]byte(query.Get("payerdata")), &payerdata)
+		if err := v.VerifyPayerData(&payerdata); err != nil {
+			json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+			return
+		}
+		v.received = append(v.received, payerdata)
+
+		hash := sha256.Sum256([]byte(params.EncodedMetadata + query.Get("payerdata")))
+		pr, _ := testInvoice(msats, hash[:])
+		json.NewEncoder(w).Encode(LNURLPayValues{
+			LNURLResponse: OkResponse(),
+			PR:            pr,
+			Routes:        []interface{}{},
+			Converted:     converted,
+			PayeeData:     v.PayeeData(payerdata, v.address, nil),
+		})
+	default:
+		if err := v.VerifyPayRequest(v.address, query); err != nil {
+			json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+			return
+		}
+		params := v.params()
+		v.Enable(&params, v.address, KYCVerified)
+		json.NewEncoder(w).Encode(params)
+	}
+}
+
+func TestUMA(t *testing.T) {
+	receiver := newStandInVASP(t, "alice")
+	sender := newStandInVASP(t, "bob")
+
+	params, err := sender.FetchPayParams(receiver.address, true)
+	if err != nil {
+		t.Fatalf("FetchPayParams() error = %v", err)
+	}
+	if params.UMAVersion != UMAVersion || len(params.Currencies) != 1 ||
+		!params.PayerData.Compliance.Mandatory || params.Compliance.KYCStatus != KYCVerified {
+		t.Errorf("FetchPayParams() got = %+v", params)
+	}
+
+	payer := PayerDataValues{LightningAddress: sender.address, FreeName: "Bob"}
+	if _, err := sender.Pay(*params, 500, "USD", payer, KYCVerified, nil); err == nil ||
+		!strings.Contains(err.Error(), "maximum is 0") {
+		t.Errorf("Pay() with a fee over MaxFee error = %v", err)
+	}
+	sender.MaxFee = 1000
+	values, err := sender.Pay(*params, 500, "USD", payer, KYCVerified, []string{"utxo:0"})
+	if err != nil {
+		t.Fatalf("Pay() error = %v", err)
+	}
+	if values.ParsedInvoice.MSatoshi != 500*20000+1000 || values.PayeeData.Identifier != receiver.address {
+		t.Errorf("Pay() got = %+v", values)
+	}
+	if len(receiver.received) != 2 || receiver.received[1].Compliance.UTXOs[0] != "utxo:0" {
+		t.Errorf("receiver got payerdata %+v", receiver.received)
+	}
+
+	if _, err := sender.Pay(*params, 500, "EUR", payer, KYCVerified, nil); err == nil {
+		t.Errorf("Pay() accepted a currency the service doesn't take")
+	}
+	if _, err := params.Call(21000, "", &payer); err == nil || !strings.Contains(err.Error(), "compliance") {
+		t.Errorf("Call() without compliance data error = %v", err)
+	}
+
+	// the sender now serves another key than the one it signs with
+	signing := sender.Key
+	sender.Key, _ = btcec.NewPrivateKey()
+	signer := &UMAVASP{Domain: sender.Domain, Key: signing}
+	if _, err := signer.FetchPayParams(receiver.address, true); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("FetchPayParams() signed with the wrong key error = %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package lnurl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// LNURLVerifyResponse is what the verify URL of LUD-21 returns for an invoice.
+type LNURLVerifyResponse struct {
+	LNURLResponse
+	Settled  bool   `json:"settled"`
+	Preimage string `json:"preimage"`
+	PR       string `json:"pr"`
+}
+
+// Verify calls the verify URL returned with the invoice (LUD-21) to check if it was paid.
+// The preimage, when returned, is checked against the payment hash.
+func (values LNURLPayValues) Verify() (*LNURLVerifyResponse, error) {
+	if values.VerifyURL == "" {
+		return nil, errors.New("service doesn't support verify")
+	}
+	verify, err := url.Parse(values.VerifyURL)
+	if err != nil {
+		return nil, errors.New("verify is not a valid URL")
+	}
+
+	resp, err := actualClient.Get(verify.String())
+	if err != nil {
+		return nil, fmt.Errorf("http error calling '%s': %w", verify.String(), err)
+	}
+	defer resp.Body.Close()
+
+	var response LNURLVerifyResponse
+	b, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(b, &response); err != nil {
+		return nil, fmt.Errorf("got invalid JSON from '%s': %w (%s)",
+			verify.String(), err, string(b))
+	}
+
+	if response.Status == "ERROR" {
+		return nil, LNURLErrorResponse{
+			Status: response.Status,
+			Reason: response.Reason,
+			URL:    verify,
+		}
+	}
+	if response.PR != "" && values.PR != "" && !strings.EqualFold(response.PR, values.PR) {
+		return nil, errors.New("verify returned the status of another invoice")
+	}
+	if response.Settled && response.Preimage != "" && values.ParsedInvoice.PaymentHash != "" {
+		preimage, err := hex.DecodeString(response.Preimage)
+		hash := sha256.Sum256(preimage)
+		if err != nil || hex.EncodeToString(hash[:]) != values.ParsedInvoice.PaymentHash {
+			return nil, errors.New("verify returned a preimage that doesn't match the payment hash")
+		}
+	}
+
+	return &response, nil
+}
+
+// WaitSettled calls Verify every interval until the invoice is settled or ctx is done.
+func (values LNURLPayValues) WaitSettled(ctx context.Context, interval time.Duration) (*LNURLVerifyResponse, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		response, err := values.Verify()
+		if err != nil {
+			return nil, err
+		}
+		if response.Settled {
+			return response, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return response, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// InvoiceStatus is what a lightning backend knows about an invoice it issued.
+type InvoiceStatus struct {
+	PR       string
+	Settled  bool
+	Preimage []byte
+}
+
+// InvoiceLookup finds invoices by their hex-encoded payment hash, returning a nil status
+// for unknown ones.
+type InvoiceLookup interface {
+	LookupInvoice(paymentHash string) (*InvoiceStatus, error)
+}
+
+// VerifyURL returns the verify URL of an invoice to be served by VerifyHandler at base.
+func VerifyURL(base string, paymentHash string) string {
+	return strings.TrimSuffix(base, "/") + "/" + paymentHash
+}
+
+// VerifyHandler serves the verify URLs of LUD-21, as returned by VerifyURL, with the
+// status of the invoices found by lookup.
+func VerifyHandler(lookup InvoiceLookup) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		paymentHash := r.URL.Path[strings.LastIndexByte(r.URL.Path, '/')+1:]
+		if b, err := hex.DecodeString(paymentHash); err != nil || len(b) != 32 {
+			json.NewEncoder(w).Encode(ErrorResponse("Invalid payment hash"))
+			return
+		}
+
+		status, err := lookup.LookupInvoice(paymentHash)
+		if err != nil {
+			json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+			return
+		}
+		if status == nil {
+			json.NewEncoder(w).Encode(ErrorResponse("Not found"))
+			return
+		}
+
+		// preimage must be null rather than empty while not settled
+		var preimage *string
+		if status.Settled && status.Preimage != nil {
+			encoded := hex.EncodeToString(status.Preimage)
+			preimage = &encoded
+		}
+		json.NewEncoder(w).Encode(struct {
+			LNURLResponse
+			Settled  bool    `json:"settled"`
+			Preimage *string `json:"preimage"`
+			PR       string  `json:"pr"`
+		}{OkResponse(), status.Settled, preimage, status.PR})
+	})
+}
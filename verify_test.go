@@ -0,0 +1,75 @@
+package lnurl
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+)
+
+type testLookup struct {
+	mu       sync.Mutex
+	invoices map[string]*InvoiceStatus
+}
+
+func (l *testLookup) LookupInvoice(paymentHash string) (*InvoiceStatus, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if status, ok := l.invoices[paymentHash]; ok {
+		copied := *status
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (l *testLookup) settle(paymentHash string, preimage []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.invoices[paymentHash].Settled = true
+	l.invoices[paymentHash].Preimage = preimage
+}
+
+func TestVerify(t *testing.T) {
+	pr, preimage := testInvoice(5000, nil)
+	invoice, _ := decodepay.Decodepay(pr)
+	lookup := &testLookup{invoices: map[string]*InvoiceStatus{invoice.PaymentHash: {PR: pr}}}
+	s := newTestServer(t, VerifyHandler(lookup))
+
+	values := LNURLPayValues{
+		PR:            pr,
+		ParsedInvoice: invoice,
+		VerifyURL:     VerifyURL(s.URL+"/verify", invoice.PaymentHash),
+	}
+	if status, err := values.Verify(); err != nil || status.Settled || status.Preimage != "" || status.PR != pr {
+		t.Errorf("Verify() before paying got = %+v, %v", status, err)
+	}
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		lookup.settle(invoice.PaymentHash, preimage)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	status, err := values.WaitSettled(ctx, 10*time.Millisecond)
+	if err != nil || !status.Settled || status.Preimage != hex.EncodeToString(preimage) {
+		t.Errorf("WaitSettled() got = %+v, %v", status, err)
+	}
+
+	lookup.settle(invoice.PaymentHash, make([]byte, 32))
+	if _, err := values.Verify(); err == nil || !strings.Contains(err.Error(), "preimage") {
+		t.Errorf("Verify() with a wrong preimage error = %v", err)
+	}
+
+	values.VerifyURL = VerifyURL(s.URL+"/verify", RandomK1())
+	if _, err := values.Verify(); err == nil || err.Error() != "Not found" {
+		t.Errorf("Verify() of an unknown invoice error = %v", err)
+	}
+	values.VerifyURL = VerifyURL(s.URL+"/verify", "xyz")
+	if _, err := values.Verify(); err == nil {
+		t.Errorf("Verify() of an invalid payment hash succeeded")
+	}
+}
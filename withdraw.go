@@ -3,8 +3,13 @@ package lnurl
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"strconv"
+
+	"github.com/tidwall/gjson"
 )
 
 type LNURLWithdrawResponse struct {
@@ -22,6 +27,118 @@ type LNURLWithdrawResponse struct {
 
 func (_ LNURLWithdrawResponse) LNURLKind() string { return "lnurl-withdraw" }
 
+// Call sends the invoice to the withdraw callback so the service can pay it. A nil error
+// only means the service accepted the request, the payment may still be in flight.
+func (r LNURLWithdrawResponse) Call(pr string) error {
+	return r.CallNotify(pr, "")
+}
+
+// CallNotify is like Call, but also sends a balanceNotify URL (LUD-15) the service will
+// POST to when the balance of the link changes, if it isn't empty.
+func (r LNURLWithdrawResponse) CallNotify(pr string, balanceNotify string) error {
+	callback := r.CallbackURL
+	if callback == nil {
+		parsed, err := url.Parse(r.Callback)
+		if err != nil {
+			return errors.New("callback is not a valid URL")
+		}
+		callback = parsed
+	}
+
+	callback = cloneURL(callback)
+	qs := callback.Query()
+	qs.Set("k1", r.K1)
+	qs.Set("pr", pr)
+	if balanceNotify != "" {
+		qs.Set("balanceNotify", balanceNotify)
+	}
+	callback.RawQuery = qs.Encode()
+
+	return getOK(callback)
+}
+
+// CheckBalance fetches the balanceCheck URL of a reusable withdraw link (LUD-14) and
+// returns the fresh withdrawRequest, which must be for the same service.
+func (r LNURLWithdrawResponse) CheckBalance() (LNURLWithdrawResponse, error) {
+	if r.BalanceCheck == "" {
+		return r, errors.New("withdraw link has no balanceCheck")
+	}
+	balanceCheck, err := url.Parse(r.BalanceCheck)
+	if err != nil {
+		return r, errors.New("balanceCheck is not a valid URL")
+	}
+
+	resp, err := actualClient.Get(balanceCheck.String())
+	if err != nil {
+		return r, fmt.Errorf("http error calling '%s': %w", balanceCheck.String(), err)
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return r, err
+	}
+
+	j := gjson.ParseBytes(b)
+	if j.Get("status").String() == "ERROR" {
+		return r, LNURLErrorResponse{
+			URL:    balanceCheck,
+			Reason: j.Get("reason").String(),
+			Status: "ERROR",
+		}
+	}
+	if tag := j.Get("tag").String(); tag != "withdrawRequest" {
+		return r, fmt.Errorf("balanceCheck returned a '%s' instead of a withdrawRequest", tag)
+	}
+
+	params, err := HandleWithdraw(b)
+	if err != nil {
+		return r, err
+	}
+	fresh := params.(LNURLWithdrawResponse)
+
+	previous, _ := url.Parse(r.Callback)
+	if previous == nil || fresh.CallbackURL.Host != previous.Host {
+		return r, fmt.Errorf("balanceCheck returned a withdrawRequest for another service (%s)",
+			fresh.CallbackURL.Host)
+	}
+
+	return fresh, nil
+}
+
+// WithBalanceCheck returns the withdrawRequest with balanceCheck set, making it a reusable
+// withdraw link (LUD-14). The URL should be served with BalanceCheckHandler.
+func (r LNURLWithdrawResponse) WithBalanceCheck(balanceCheck string) LNURLWithdrawResponse {
+	r.BalanceCheck = balanceCheck
+	return r
+}
+
+// BalanceCheckHandler serves the balanceCheck URL of reusable withdraw links. get returns
+// the withdrawRequest of the link with its current balance, or an error that is sent as
+// the reason of an ERROR response. If the withdrawRequest has no balanceCheck the
+// requested URL is used, so wallets can keep checking.
+func BalanceCheckHandler(get func(r *http.Request) (LNURLWithdrawResponse, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		params, err := get(r)
+		if err != nil {
+			json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+			return
+		}
+
+		params.Tag = "withdrawRequest"
+		if params.BalanceCheck == "" {
+			scheme := "https"
+			if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+				scheme = "http"
+			}
+			params.BalanceCheck = scheme + "://" + r.Host + r.URL.RequestURI()
+		}
+		json.NewEncoder(w).Encode(params)
+	})
+}
+
 func HandleWithdraw(raw []byte) (LNURLParams, error) {
 	var params LNURLWithdrawResponse
 	err := json.Unmarshal(raw, &params)
@@ -0,0 +1,64 @@
+package lnurl
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+)
+
+func TestCheckBalance(t *testing.T) {
+	balance := int64(50000000)
+	mux := http.NewServeMux()
+	s := newTestServer(t, mux)
+	mux.Handle("/withdraw", BalanceCheckHandler(func(r *http.Request) (LNURLWithdrawResponse, error) {
+		if balance == 0 {
+			return LNURLWithdrawResponse{}, errors.New("link is empty")
+		}
+		return LNURLWithdrawResponse{
+			K1:              "link",
+			Callback:        s.URL + "/callback",
+			MinWithdrawable: 1000,
+			MaxWithdrawable: balance,
+		}, nil
+	}))
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		invoice, _ := decodepay.Decodepay(r.URL.Query().Get("pr"))
+		balance -= invoice.MSatoshi
+		w.Write([]byte(`{"status":"OK"}`))
+	})
+
+	_, params, err := HandleLNURL(s.URL + "/withdraw")
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	withdraw := params.(LNURLWithdrawResponse)
+	if withdraw.BalanceCheck != s.URL+"/withdraw" {
+		t.Errorf("BalanceCheckHandler() served balanceCheck %s", withdraw.BalanceCheck)
+	}
+
+	pr, _ := testInvoice(30000000, nil)
+	if err := withdraw.Call(pr); err != nil {
+		t.Fatalf("Call() error = %v", err)
+	}
+	fresh, err := withdraw.CheckBalance()
+	if err != nil {
+		t.Fatalf("CheckBalance() error = %v", err)
+	}
+	if fresh.MaxWithdrawable != 20000000 || fresh.BalanceCheck != withdraw.BalanceCheck {
+		t.Errorf("CheckBalance() got = %+v", fresh)
+	}
+
+	balance = 0
+	if _, err := withdraw.CheckBalance(); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("CheckBalance() of an empty link error = %v", err)
+	}
+
+	balance = 1000000
+	withdraw.Callback = "https://other.com/callback"
+	if _, err := withdraw.CheckBalance(); err == nil {
+		t.Errorf("CheckBalance() accepted a withdrawRequest for another service")
+	}
+}
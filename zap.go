@@ -0,0 +1,203 @@
+package lnurl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+)
+
+// ZapPublisher sends zap receipts to Nostr relays.
+type ZapPublisher interface {
+	PublishZapReceipt(relays []string, receipt NostrEvent) error
+}
+
+// ValidateZapRequest parses and checks the zap request a wallet sent in the nostr parameter
+// of a pay callback for msats, following NIP-57: it must be a signed kind 9734 event with a
+// single p tag, at most one e tag, a relays tag with ws(s) URLs and, if there is an amount
+// tag, the same amount.
+func ValidateZapRequest(nostr string, msats int64) (*NostrEvent, error) {
+	var zapRequest NostrEvent
+	if err := json.Unmarshal([]byte(nostr), &zapRequest); err != nil {
+		return nil, fmt.Errorf("zap request is not a valid event: %w", err)
+	}
+
+	if zapRequest.Kind != KindZapRequest {
+		return nil, fmt.Errorf("zap request must be of kind %d, not %d", KindZapRequest, zapRequest.Kind)
+	}
+	if err := zapRequest.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid zap request: %w", err)
+	}
+
+	counts := make(map[string]int)
+	for _, tag := range zapRequest.Tags {
+		if len(tag) > 0 {
+			counts[tag[0]]++
+		}
+	}
+	if counts["p"] != 1 {
+		return nil, errors.New("zap request must have exactly one p tag")
+	}
+	if p := zapRequest.Tag("p"); len(p) < 2 || len(p[1]) != 64 {
+		return nil, errors.New("zap request p tag is not a pubkey")
+	}
+	if counts["e"] > 1 {
+		return nil, errors.New("zap request must have at most one e tag")
+	}
+
+	relays := zapRequest.Tag("relays")
+	if len(relays) < 2 {
+		return nil, errors.New("zap request has no relays")
+	}
+	for _, relay := range relays[1:] {
+		parsed, err := url.Parse(relay)
+		if err != nil || parsed.Host == "" || (parsed.Scheme != "wss" && parsed.Scheme != "ws") {
+			return nil, fmt.Errorf("zap request has an invalid relay '%s'", relay)
+		}
+	}
+
+	if amount := zapRequest.Tag("amount"); amount != nil {
+		if len(amount) < 2 || amount[1] != strconv.FormatInt(msats, 10) {
+			return nil, fmt.Errorf("zap request amount doesn't match the amount of %d msats", msats)
+		}
+	}
+
+	return &zapRequest, nil
+}
+
+// NewZapReceipt returns the kind 9735 zap receipt for a paid invoice issued for the zap
+// request nostr, as received, signed by key, whose public key must be the nostrPubkey of
+// the service.
+func NewZapReceipt(nostr string, pr string, preimage []byte, paidAt time.Time, key *btcec.PrivateKey) (NostrEvent, error) {
+	var zapRequest NostrEvent
+	if err := json.Unmarshal([]byte(nostr), &zapRequest); err != nil {
+		return NostrEvent{}, fmt.Errorf("zap request is not a valid event: %w", err)
+	}
+
+	receipt := NostrEvent{
+		CreatedAt: paidAt.Unix(),
+		Kind:      KindZapReceipt,
+		Tags:      make([][]string, 0, 7),
+	}
+	for _, name := range []string{"p", "e", "a"} {
+		if tag := zapRequest.Tag(name); tag != nil {
+			receipt.Tags = append(receipt.Tags, tag)
+		}
+	}
+	receipt.Tags = append(receipt.Tags,
+		[]string{"P", zapRequest.PubKey},
+		[]string{"bolt11", pr},
+		[]string{"description", nostr},
+	)
+	if preimage != nil {
+		receipt.Tags = append(receipt.Tags, []string{"preimage", hex.EncodeToString(preimage)})
+	}
+
+	if err := receipt.Sign(key); err != nil {
+		return NostrEvent{}, err
+	}
+	return receipt, nil
+}
+
+// ZapServer adds zaps to an lnurl-pay service: the callback checks zap requests with
+// DescriptionHash and reports the invoices issued for them with Issued, and once one is
+// paid Paid publishes its receipt.
+type ZapServer struct {
+	// Key signs the zap receipts, its public key is the nostrPubkey of the service.
+	Key *btcec.PrivateKey
+
+	// Publisher sends the receipts, Paid fails without one.
+	Publisher ZapPublisher
+
+	mu      sync.Mutex
+	pending map[string]pendingZap
+}
+
+type pendingZap struct {
+	nostr   string
+	expires time.Time
+}
+
+// Enable sets allowsNostr and nostrPubkey in the params served by the service.
+func (z *ZapServer) Enable(params *LNURLPayParams) {
+	params.AllowsNostr = true
+	params.NostrPubkey = hex.EncodeToString(schnorr.SerializePubKey(z.Key.PubKey()))
+}
+
+// DescriptionHash validates the zap request received in the nostr parameter of the
+// callback and returns the description hash the invoice must have.
+func (z *ZapServer) DescriptionHash(nostr string, msats int64) ([]byte, error) {
+	if _, err := ValidateZapRequest(nostr, msats); err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(nostr))
+	return hash[:], nil
+}
+
+// Issued records the invoice issued for a zap request, so Paid can publish its receipt.
+// Invoices are forgotten once they expire.
+func (z *ZapServer) Issued(nostr string, pr string) {
+	now := time.Now()
+	// an invoice that can't be decoded gets the default expiry of an hour
+	expires := now.Add(time.Hour)
+	if invoice, err := decodepay.Decodepay(pr); err == nil {
+		expires = time.Unix(int64(invoice.CreatedAt+invoice.Expiry), 0)
+	}
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.pending == nil {
+		z.pending = make(map[string]pendingZap)
+	}
+	for other, zap := range z.pending {
+		if now.After(zap.expires) {
+			delete(z.pending, other)
+		}
+	}
+	z.pending[pr] = pendingZap{nostr, expires}
+}
+
+// Paid publishes the zap receipt for an invoice reported with Issued to the relays of
+// its zap request. Invoices that weren't for zaps are ignored. If the receipt can't be
+// published the invoice is kept, so Paid can be called again.
+func (z *ZapServer) Paid(pr string, preimage []byte) error {
+	if z.Publisher == nil {
+		return errors.New("zap server has no publisher")
+	}
+
+	z.mu.Lock()
+	zap, ok := z.pending[pr]
+	z.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	receipt, err := NewZapReceipt(zap.nostr, pr, preimage, time.Now(), z.Key)
+	if err != nil {
+		return err
+	}
+
+	var zapRequest NostrEvent
+	json.Unmarshal([]byte(zap.nostr), &zapRequest)
+	relays := zapRequest.Tag("relays")
+	if len(relays) < 2 {
+		return errors.New("zap request has no relays")
+	}
+	if err := z.Publisher.PublishZapReceipt(relays[1:], receipt); err != nil {
+		return err
+	}
+
+	z.mu.Lock()
+	delete(z.pending, pr)
+	z.mu.Unlock()
+	return nil
+}
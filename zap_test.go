@@ -0,0 +1,112 @@
+package lnurl
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+type testPublisher struct {
+	fail     bool
+	receipts []NostrEvent
+}
+
+func (p *testPublisher) PublishZapReceipt(relays []string, receipt NostrEvent) error {
+	if p.fail {
+		return errors.New("relay is down")
+	}
+	p.receipts = append(p.receipts, receipt)
+	return nil
+}
+
+func TestZapServer(t *testing.T) {
+	serviceKey, _ := btcec.NewPrivateKey()
+	zaps := &ZapServer{Key: serviceKey}
+	var issued []string
+	var callback string
+	s := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			params := LNURLPayParams{
+				Tag:             "payRequest",
+				Callback:        callback,
+				MinSendable:     1000,
+				MaxSendable:     100000,
+				EncodedMetadata: `[["text/plain","zap"]]`,
+			}
+			zaps.Enable(&params)
+			json.NewEncoder(w).Encode(params)
+			return
+		}
+
+		nostr := r.URL.Query().Get("nostr")
+		msats, _ := strconv.ParseInt(r.URL.Query().Get("amount"), 10, 64)
+		descriptionHash, err := zaps.DescriptionHash(nostr, msats)
+		if err != nil {
+			json.NewEncoder(w).Encode(ErrorResponse(err.Error()))
+			return
+		}
+		pr, _ := testInvoice(msats, descriptionHash)
+		zaps.Issued(nostr, pr)
+		issued = append(issued, pr)
+		json.NewEncoder(w).Encode(LNURLPayValues{LNURLResponse: OkResponse(), PR: pr})
+	}))
+	callback = s.URL + "/callback"
+
+	_, params, err := HandleLNURL(s.URL)
+	if err != nil {
+		t.Fatalf("HandleLNURL() error = %v", err)
+	}
+	pay := params.(LNURLPayParams)
+
+	senderKey, _ := btcec.NewPrivateKey()
+	recipient := strings.Repeat("ab", 32)
+	zap := NewZapRequest(recipient, 21000, "lnurl1", []string{"wss://relay.example.com"}, "great post")
+	zap.Sign(senderKey)
+	values, err := pay.CallZap(21000, zap)
+	if err != nil {
+		t.Fatalf("CallZap() error = %v", err)
+	}
+
+	if err := zaps.Paid(values.PR, nil); err == nil {
+		t.Errorf("Paid() without a publisher succeeded")
+	}
+	publisher := &testPublisher{fail: true}
+	zaps.Publisher = publisher
+	if err := zaps.Paid(values.PR, nil); err == nil {
+		t.Errorf("Paid() succeeded with a failing publisher")
+	}
+	publisher.fail = false
+	if err := zaps.Paid(values.PR, nil); err != nil {
+		t.Fatalf("Paid() error = %v", err)
+	}
+	if len(publisher.receipts) != 1 || publisher.receipts[0].PubKey != pay.NostrPubkey ||
+		publisher.receipts[0].Tag("bolt11")[1] != values.PR {
+		t.Errorf("Paid() published %v", publisher.receipts)
+	}
+	if err := zaps.Paid(values.PR, nil); err != nil || len(publisher.receipts) != 1 {
+		t.Errorf("Paid() twice published %d receipts, %v", len(publisher.receipts), err)
+	}
+
+	zaps.pending["expired"] = pendingZap{expires: time.Now().Add(-time.Minute)}
+	if _, err := pay.CallZap(21000, zap); err != nil {
+		t.Fatalf("CallZap() error = %v", err)
+	}
+	if _, ok := zaps.pending["expired"]; ok || len(zaps.pending) != 1 {
+		t.Errorf("Issued() kept %d pending invoices", len(zaps.pending))
+	}
+	if expires := zaps.pending[issued[1]].expires; time.Until(expires) < 59*time.Minute {
+		t.Errorf("Issued() set the invoice to expire at %v", expires)
+	}
+
+	zap.Tags = append(zap.Tags, []string{"p", recipient})
+	zap.Sign(senderKey)
+	if _, err := pay.CallZap(21000, zap); err == nil || !strings.Contains(err.Error(), "one p tag") {
+		t.Errorf("CallZap() with two p tags error = %v", err)
+	}
+}